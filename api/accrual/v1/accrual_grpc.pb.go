@@ -0,0 +1,111 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: accrual.proto
+
+package accrualv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Accrual_GetOrder_FullMethodName = "/gophermart.accrual.v1.Accrual/GetOrder"
+)
+
+// AccrualClient is the client API for Accrual service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AccrualClient interface {
+	// GetOrder returns the accrual calculation for the order, NOT_FOUND if the order is unknown
+	GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error)
+}
+
+type accrualClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAccrualClient(cc grpc.ClientConnInterface) AccrualClient {
+	return &accrualClient{cc}
+}
+
+func (c *accrualClient) GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, Accrual_GetOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AccrualServer is the server API for Accrual service.
+// All implementations must embed UnimplementedAccrualServer
+// for forward compatibility
+type AccrualServer interface {
+	// GetOrder returns the accrual calculation for the order, NOT_FOUND if the order is unknown
+	GetOrder(context.Context, *GetOrderRequest) (*Order, error)
+	mustEmbedUnimplementedAccrualServer()
+}
+
+// UnimplementedAccrualServer must be embedded to have forward compatible implementations.
+type UnimplementedAccrualServer struct {
+}
+
+func (UnimplementedAccrualServer) GetOrder(context.Context, *GetOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedAccrualServer) mustEmbedUnimplementedAccrualServer() {}
+
+// UnsafeAccrualServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AccrualServer will
+// result in compilation errors.
+type UnsafeAccrualServer interface {
+	mustEmbedUnimplementedAccrualServer()
+}
+
+func RegisterAccrualServer(s grpc.ServiceRegistrar, srv AccrualServer) {
+	s.RegisterService(&Accrual_ServiceDesc, srv)
+}
+
+func _Accrual_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AccrualServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Accrual_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AccrualServer).GetOrder(ctx, req.(*GetOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Accrual_ServiceDesc is the grpc.ServiceDesc for Accrual service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Accrual_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gophermart.accrual.v1.Accrual",
+	HandlerType: (*AccrualServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetOrder",
+			Handler:    _Accrual_GetOrder_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "accrual.proto",
+}
@@ -0,0 +1,4 @@
+// Package accrualv1 holds the gRPC API of accrual systems
+package accrualv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative accrual.proto
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/accrualmock"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+)
+
+func main() {
+	addr := flag.String("a", "localhost:8081", "Server address. Usage: -a=host:port")
+	latency := flag.Duration("latency", 0, "Maximum random latency of a response")
+	errorRate := flag.Float64("error-rate", 0, "Share of requests failing with 500, from 0 to 1")
+	ratePerMinute := flag.Int("rate", 0, "Requests per minute allowed before responding with 429, 0 means unlimited")
+	retryAfter := flag.Duration("retry-after", time.Minute, "Retry-After sent with 429 responses")
+	rewardMin := flag.Float64("reward-min", 100, "Minimum random reward")
+	rewardMax := flag.Float64("reward-max", 1000, "Maximum random reward")
+	autoRegister := flag.Bool("auto-register", false, "Process unknown orders instead of responding with 204")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", logging.FormatText, "Log format: json or text")
+	flag.Parse()
+
+	logger, err := logging.SetupLogger(*logLevel, *logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mock := accrualmock.NewServer(logger, accrualmock.Options{
+		Latency:       *latency,
+		ErrorRate:     *errorRate,
+		RatePerMinute: *ratePerMinute,
+		RetryAfter:    *retryAfter,
+		RewardMin:     float32(*rewardMin),
+		RewardMax:     float32(*rewardMax),
+		AutoRegister:  *autoRegister,
+	})
+	r := chi.NewRouter()
+	r.Use(logging.RequestIDMiddleware)
+	r.Use(logging.Middleware(logger))
+	r.Mount("/", mock.Route())
+	logger.Infof("Launching the accrual mock at %s", *addr)
+	if err := http.ListenAndServe(*addr, r); err != nil {
+		logger.Fatal(err)
+	}
+}
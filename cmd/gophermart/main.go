@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -16,71 +15,341 @@ import (
 	"github.com/matthiasBT/gophermart/internal/infra/auth"
 	"github.com/matthiasBT/gophermart/internal/infra/config"
 	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/metrics"
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
 	"github.com/matthiasBT/gophermart/internal/server/adapters"
 	"github.com/matthiasBT/gophermart/internal/server/adapters/repositories"
 	"github.com/matthiasBT/gophermart/internal/server/entities"
 	"github.com/matthiasBT/gophermart/internal/server/usecases"
 )
 
-func setupServer(logger logging.ILogger, userRepo entities.UserRepo, controller *usecases.BaseController) *chi.Mux {
+func setupServer(
+	logger logging.ILogger,
+	userRepo entities.UserRepo,
+	controller *usecases.BaseController,
+	adminController *usecases.AdminController,
+	healthController *usecases.HealthController,
+	adminToken string,
+	exposeMetrics bool,
+) *chi.Mux {
 	r := chi.NewRouter()
-	r.Use(logging.Middleware(logger))
-	r.Use(auth.Middleware(logger, userRepo))
-	r.Mount("/api", controller.Route())
+	r.Use(logging.RequestIDMiddleware)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware(logger, "/healthz", "/readyz"))
+	r.Use(metrics.Middleware)
+	r.Mount("/", healthController.Route())
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(logger, userRepo))
+		r.Mount("/api", controller.Route())
+	})
+	if adminToken == "" {
+		logger.Warningf("ADMIN_TOKEN is not set, the admin API is disabled")
+		if exposeMetrics {
+			logger.Warningf("Neither ADMIN_TOKEN nor METRICS_ADDRESS is set, /metrics is disabled")
+		}
+		return r
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AdminMiddleware(logger, adminToken))
+		r.Mount("/admin", adminController.Route())
+		if exposeMetrics {
+			r.Handle("/metrics", metrics.Handler())
+		}
+	})
 	return r
 }
 
-func gracefulShutdown(srv *http.Server, done chan struct{}, logger logging.ILogger) {
+func gracefulShutdown(
+	servers []*http.Server,
+	probe *adapters.ReadinessProbe,
+	readinessDelay time.Duration,
+	supervisor *adapters.Supervisor,
+	drainTimeout time.Duration,
+	logger logging.ILogger,
+) error {
 	quitChannel := make(chan os.Signal, 1)
 	signal.Notify(quitChannel, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-quitChannel
 	logger.Infof("Received signal: %v\n", sig)
-	done <- struct{}{}
-	time.Sleep(2 * time.Second)
 
-	if err := srv.Shutdown(context.Background()); err != nil {
-		log.Fatalf("Server shutdown failed: %v\n", err.Error())
+	probe.ShuttingDown()
+	logger.Infof("Reporting unready for %v before closing the listener", readinessDelay)
+	time.Sleep(readinessDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout)
+	defer cancel()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			// the workers are drained all the same, so that claimed orders aren't abandoned
+			logger.Errorf("Server shutdown failed: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	supervisor.Stop(drainTimeout)
+	return errors.Join(errs...)
+}
+
+// providerRate is the rate limit from the provider's own configuration
+func providerRate(p *config.ProviderConfig) (int, int) {
+	return p.RatePerMinute, p.RateBurst
+}
+
+func setupAccrualProviders(
+	logger logging.ILogger,
+	conf *config.Config,
+	httpClient *http.Client,
+	rate func(p *config.ProviderConfig) (int, int),
+) (*adapters.AccrualProviderRegistry, func(), error) {
+	providersConf, err := config.ReadProviders(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry := adapters.NewAccrualProviderRegistry(logger, providersConf)
+	var grpcClients []*adapters.GRPCAccrualClient
+	closeAll := func() {
+		for _, client := range grpcClients {
+			client.Close()
+		}
 	}
+	for _, p := range providersConf.Providers {
+		limiter := adapters.NewRateLimiter(rate(&p))
+		breaker := adapters.NewCircuitBreaker(
+			logger, conf.BreakerFailureThreshold, conf.BreakerOpenTimeout, conf.BreakerHalfOpenCalls,
+		)
+		var provider entities.IAccrualProvider
+		switch p.Kind {
+		case config.ProviderKindGRPC:
+			client, err := adapters.NewGRPCAccrualClient(
+				logger,
+				p.Address,
+				p.Insecure,
+				p.Credentials,
+				p.MaxAttempts,
+				p.Timeout.Duration,
+				config.AccrualRetryBaseDelay,
+				config.AccrualRetryMaxDelay,
+				limiter,
+				breaker,
+			)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			grpcClients = append(grpcClients, client)
+			provider = client
+		default:
+			provider = adapters.NewAccrualClient(
+				logger,
+				p.Address,
+				httpClient,
+				p.Credentials,
+				config.DefaultAccrualRequestTimeoutSec,
+				p.MaxAttempts,
+				p.Timeout.Duration,
+				config.AccrualRetryBaseDelay,
+				config.AccrualRetryMaxDelay,
+				limiter,
+				breaker,
+			)
+		}
+		registry.Register(p.Name, p.Kind, provider, limiter, breaker)
+	}
+	return registry, closeAll, nil
 }
 
 func main() {
-	logger := logging.SetupLogger()
 	conf, err := config.Read()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger, err := logging.SetupLogger(conf.LogLevel, conf.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdownTracing, err := tracing.Setup(
+		context.Background(), conf.TracingExporter, conf.TracingFile, conf.TracingServiceName,
+	)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	storage := adapters.NewPGStorage(logger, conf.DatabaseDSN)
-	defer storage.Shutdown()
+	exitCode := 0
+	// deferred first, so it exits after the other deferred cleanups have run
+	defer func() { os.Exit(exitCode) }()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Errorf("Failed to flush traces: %v", err)
+		}
+	}()
+	pgStorage := adapters.NewPGStorage(logger, conf.DatabaseDSN)
+	defer pgStorage.Shutdown()
+	storage := adapters.NewTracedStorage(pgStorage)
 	userRepo := repositories.NewPGUserRepo(logger, storage)
 	orderRepo := repositories.NewPGOrderRepo(logger, storage)
 	accrualRepo := repositories.NewPGAccrualRepo(logger, storage)
+	webhookRepo := repositories.NewPGWebhookRepo(logger, storage)
+	eventRepo := repositories.NewPGEventRepo(logger, storage)
 	crypto := adapters.CryptoProvider{Logger: logger}
-	controller := usecases.NewBaseController(logger, storage, userRepo, orderRepo, accrualRepo, &crypto)
-	r := setupServer(logger, userRepo, controller)
-	srv := http.Server{Addr: conf.ServerAddr, Handler: r}
+	validators, err := adapters.NewOrderValidatorRegistry(
+		logger,
+		conf.OrderValidator,
+		conf.PartnerValidators,
+		conf.OrderNumberPrefix,
+		conf.OrderNumberPattern,
+		conf.PartnerPrefixes,
+		conf.PartnerPatterns,
+	)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	events := adapters.NewEventBus(logger, config.EventsSubscriberBuffer)
+	accrualHTTPClient := adapters.NewHTTPClient(
+		conf.AccrualMaxIdleConns,
+		conf.AccrualMaxIdleConnsPerHost,
+		conf.AccrualIdleConnTimeout,
+		conf.AccrualDialTimeout,
+		conf.AccrualKeepAlive,
+		conf.AccrualTLSHandshakeTimeout,
+		conf.AccrualResponseHeaderTimeout,
+		conf.AccrualHTTP2,
+	)
+	providers, closeProviders, err := setupAccrualProviders(logger, conf, accrualHTTPClient, providerRate)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer closeProviders()
+	reconcileProviders, closeReconcileProviders, err := setupAccrualProviders(
+		logger,
+		conf,
+		accrualHTTPClient,
+		func(*config.ProviderConfig) (int, int) { return conf.ReconcileRatePerMinute, conf.ReconcileRateBurst },
+	)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer closeReconcileProviders()
+	supervisor := adapters.NewSupervisor(context.Background(), logger, config.WorkerRestartDelay)
 
-	done := make(chan struct{}, 1)
-	jobs := make(chan entities.Job, config.WorkerJobsCapacity)
+	jobs := make(chan entities.Job, conf.WorkerQueueCapacity)
+	tracker := adapters.NewJobTracker()
+	instanceID := config.InstanceID()
 	supplier := adapters.NewSupplier(
-		storage, orderRepo, logger, jobs, done, time.NewTicker(config.WorkerInterval).C, config.WorkerJobsCapacity,
+		providers,
+		instanceID,
+		config.OrderLease,
+		config.OrderMaxAge,
+		storage,
+		orderRepo,
+		logger,
+		jobs,
+		tracker,
+		time.NewTicker(config.WorkerInterval).C,
+		conf.WorkerQueueCapacity,
 	)
-	ctx := context.Background()
-	go supplier.Run(ctx)
-	accrualDriver := adapters.NewAccrualClient(
-		logger, conf.AccrualAddr, config.DefaultAccrualRequestTimeoutSec, config.MaxAccrualRequestAttempts,
+	supervisor.Go("supplier", supplier.Run)
+	controller := usecases.NewBaseController(
+		logger, storage, userRepo, orderRepo, accrualRepo, webhookRepo, eventRepo, &crypto, validators, events, supplier,
+		providers,
 	)
-	for i := 0; i < config.WorkerPoolSize; i++ {
-		worker := adapters.NewCollector(
-			fmt.Sprintf("worker-%d", i),
-			accrualDriver,
+	dispatcher := adapters.NewWebhookDispatcher(
+		webhookRepo,
+		logger,
+		time.NewTicker(config.WebhookInterval).C,
+		config.WebhookBatchSize,
+		config.WebhookRequestTimeout,
+		config.MaxWebhookAttempts,
+		config.WebhookBaseRetryDelay,
+		config.WebhookMaxRetryDelay,
+	)
+	supervisor.Go("webhook-dispatcher", dispatcher.Run)
+	relay := adapters.NewOutboxRelay(
+		storage,
+		eventRepo,
+		logger,
+		config.EventsChannel,
+		time.NewTicker(config.OutboxRelayInterval).C,
+		config.OutboxRelayBatchSize,
+	)
+	supervisor.Go("outbox-relay", relay.Run)
+	listener := adapters.NewPGListener(logger, conf.DatabaseDSN, config.ListenerRetryDelay)
+	listener.Handle(config.EventsChannel, events.HandleNotification)
+	listener.Handle(config.OrdersChannel, supplier.WakeOnNotification)
+	supervisor.Go("notification-listener", listener.Run)
+	newCollector := func(name string, client entities.IAccrualClient, jobs <-chan entities.Job) *adapters.Collector {
+		return adapters.NewCollector(
+			name,
+			instanceID,
+			config.OrderLease,
+			config.OrderRetryBaseDelay,
+			config.OrderRetryMaxDelay,
+			conf.OrderMaxFailures,
+			client,
 			storage,
 			orderRepo,
 			accrualRepo,
+			webhookRepo,
+			eventRepo,
 			logger,
 			jobs,
-			done,
+			tracker,
 		)
-		go worker.Run(ctx)
+	}
+	accrualClient := adapters.NewCoalescingAccrualClient(
+		logger, providers, conf.AccrualCacheTTL, config.AccrualCacheMaxEntries, config.AccrualLookupTimeout,
+	)
+	pool, err := adapters.NewCollectorPool(
+		logger,
+		supervisor,
+		providers,
+		accrualClient,
+		newCollector,
+		jobs,
+		tracker,
+		time.NewTicker(config.WorkerScaleInterval).C,
+		conf.WorkerPoolMin,
+		conf.WorkerPoolMax,
+		conf.WorkerMaxLatency,
+	)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	supervisor.Go("collector-pool", pool.Run)
+
+	reconciler := adapters.NewReconciler(
+		reconcileProviders, storage, orderRepo, accrualRepo, logger, config.ReconcileMaxOrders, config.ReconcileKeepJobs,
+	)
+	supervisor.Go("reconciler", reconciler.Run)
+	adminController := usecases.NewAdminController(
+		logger, userRepo, orderRepo, providers, supervisor, pool, supplier, reconciler,
+	)
+	orderCounter := metrics.NewOrderCounter(logger, orderRepo, time.NewTicker(config.OrderCountInterval).C)
+	supervisor.Go("order-counter", orderCounter.Run)
+	metrics.RegisterStateCollectors(pgStorage.DB(), pool, orderCounter, providers)
+	probe := adapters.NewReadinessProbe(config.ReadinessCheckTimeout)
+	probe.Register("database", pgStorage.Ping)
+	probe.Register("migrations", pgStorage.CheckMigrations)
+	probe.RegisterDetail("accrual", adapters.BreakerCheck(providers))
+	probe.Register("workers", adapters.WorkersCheck(supervisor))
+	healthController := usecases.NewHealthController(logger, probe)
+	r := setupServer(
+		logger, userRepo, controller, adminController, healthController, conf.AdminToken, conf.MetricsAddr == "",
+	)
+	srv := http.Server{Addr: conf.ServerAddr, Handler: r}
+	srv.RegisterOnShutdown(events.Close)
+	servers := []*http.Server{&srv}
+	if conf.MetricsAddr != "" {
+		mr := chi.NewRouter()
+		mr.Handle("/metrics", metrics.Handler())
+		metricsSrv := http.Server{Addr: conf.MetricsAddr, Handler: mr}
+		servers = append(servers, &metricsSrv)
+		go func() {
+			logger.Infof("Launching the metrics server at %s\n", conf.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatal(err)
+			}
+		}()
 	}
 
 	go func() {
@@ -90,5 +359,8 @@ func main() {
 		}
 	}()
 
-	gracefulShutdown(&srv, done, logger)
+	err = gracefulShutdown(servers, probe, conf.ShutdownReadinessDelay, supervisor, conf.WorkerDrainTimeout, logger)
+	if err != nil {
+		exitCode = 1
+	}
 }
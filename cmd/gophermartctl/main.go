@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const usage = `Usage: gophermartctl [-addr URL] [-token TOKEN] <command> [arguments]
+
+Commands:
+  failed [-dead] [-status S,...] [-limit N]          list orders with recorded failures
+  inspect <number>                                   show an order with its failures and status history
+  requeue [-force] <number>...                       requeue orders, -force re-polls PROCESSED ones
+  requeue-failed [-dead] [-status S,...] [-limit N]  requeue failed orders matching the filter
+  reconcile -from DATE -to DATE [-adjust] [-wait] [-format json|csv]
+                                                     compare orders with the accrual system in the background,
+                                                     dates in RFC3339, -wait polls until the report is ready
+  reconciliation [-format json|csv] [id]             show a reconciliation, without an id list the recent ones
+  set-partner <login> [partner]                      assign a user to a partner, without one the user is unassigned
+`
+
+const reconcilePollInterval = 2 * time.Second
+
+type adminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	out     io.Writer
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	addr := flag.String("addr", envOr("GOPHERMART_ADMIN_URL", "http://localhost:8080"), "Gophermart base URL")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "Admin API token")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	client := &adminClient{
+		baseURL: strings.TrimRight(*addr, "/") + "/admin",
+		token:   *token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		out:     os.Stdout,
+	}
+	if err := run(client, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(client *adminClient, command string, args []string) error {
+	switch command {
+	case "failed":
+		filter, err := parseFailedOrderFilter(command, args)
+		if err != nil {
+			return err
+		}
+		query := url.Values{"dead": {strconv.FormatBool(filter.DeadOnly)}, "limit": {strconv.Itoa(filter.Limit)}}
+		for _, status := range filter.Statuses {
+			query.Add("status", string(status))
+		}
+		return client.do(http.MethodGet, "/orders/failed", query, nil)
+	case "inspect":
+		if len(args) != 1 {
+			return errors.New("inspect expects exactly one order number")
+		}
+		return client.do(http.MethodGet, "/orders/"+url.PathEscape(args[0]), nil, nil)
+	case "requeue":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		force := flags.Bool("force", false, "Re-poll PROCESSED orders")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return errors.New("requeue expects at least one order number")
+		}
+		query := url.Values{"force": {strconv.FormatBool(*force)}}
+		for _, number := range flags.Args() {
+			if err := client.do(http.MethodPost, "/orders/"+url.PathEscape(number)+"/requeue", query, nil); err != nil {
+				return fmt.Errorf("order %s: %w", number, err)
+			}
+		}
+		return nil
+	case "requeue-failed":
+		filter, err := parseFailedOrderFilter(command, args)
+		if err != nil {
+			return err
+		}
+		return client.do(http.MethodPost, "/orders/failed/requeue", nil, filter)
+	case "reconcile":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		from := flags.String("from", "", "Start of the upload date range, RFC3339")
+		to := flags.String("to", time.Now().Format(time.RFC3339), "End of the upload date range, RFC3339")
+		adjust := flags.Bool("adjust", false, "Post corrective adjustments")
+		wait := flags.Bool("wait", false, "Wait for the reconciliation to finish and show its report")
+		format := flags.String("format", "json", "Report format with -wait: json or csv")
+		flags.Parse(args)
+		req := entities.ReconciliationRequest{Adjust: *adjust}
+		var err error
+		if req.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+		if req.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+		if !*wait {
+			return client.do(http.MethodPost, "/reconciliations", nil, &req)
+		}
+		var job entities.ReconciliationJob
+		if err := client.call(http.MethodPost, "/reconciliations", nil, &req, &job); err != nil {
+			return err
+		}
+		return client.awaitReconciliation(job.ID, *format)
+	case "reconciliation":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		format := flags.String("format", "json", "Report format: json or csv")
+		flags.Parse(args)
+		switch flags.NArg() {
+		case 0:
+			return client.do(http.MethodGet, "/reconciliations", nil, nil)
+		case 1:
+			path := "/reconciliations/" + url.PathEscape(flags.Arg(0))
+			return client.do(http.MethodGet, path, url.Values{"format": {*format}}, nil)
+		default:
+			return errors.New("reconciliation expects at most one id")
+		}
+	case "set-partner":
+		if len(args) != 1 && len(args) != 2 {
+			return errors.New("set-partner expects a login and an optional partner")
+		}
+		var assignment entities.PartnerAssignment
+		if len(args) == 2 {
+			assignment.Partner = &args[1]
+		}
+		return client.do(http.MethodPut, "/users/"+url.PathEscape(args[0])+"/partner", nil, &assignment)
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+}
+
+func parseFailedOrderFilter(command string, args []string) (*entities.FailedOrderFilter, error) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dead := flags.Bool("dead", false, "Only dead-lettered orders")
+	statuses := flags.String("status", "", "Comma-separated order statuses")
+	limit := flags.Int("limit", 100, "Maximum number of orders")
+	flags.Parse(args)
+	filter := entities.FailedOrderFilter{DeadOnly: *dead, Limit: *limit}
+	if *statuses == "" {
+		return &filter, nil
+	}
+	for _, value := range strings.Split(*statuses, ",") {
+		status, err := entities.ParseOrderStatus(strings.ToUpper(strings.TrimSpace(value)))
+		if err != nil {
+			return nil, err
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	return &filter, nil
+}
+
+// awaitReconciliation polls the reconciliation until it's done and prints its report
+func (c *adminClient) awaitReconciliation(id int64, format string) error {
+	path := "/reconciliations/" + strconv.FormatInt(id, 10)
+	for {
+		var job entities.ReconciliationJob
+		if err := c.call(http.MethodGet, path, nil, nil, &job); err != nil {
+			return err
+		}
+		if job.Done() {
+			if job.State == entities.ReconciliationFailed {
+				return fmt.Errorf("reconciliation %d failed: %s", id, job.Error)
+			}
+			return c.do(http.MethodGet, path, url.Values{"format": {format}}, nil)
+		}
+		if job.Report != nil {
+			fmt.Fprintf(os.Stderr, "Reconciliation %d: %d of %d orders checked\n", id, job.Report.Checked, job.Report.Total)
+		}
+		time.Sleep(reconcilePollInterval)
+	}
+}
+
+// do sends the request and prints the response
+func (c *adminClient) do(method string, path string, query url.Values, payload any) error {
+	data, err := c.send(method, path, query, payload)
+	if err != nil {
+		return err
+	}
+	var formatted bytes.Buffer
+	if err := json.Indent(&formatted, data, "", "  "); err != nil {
+		_, err = c.out.Write(data)
+		return err
+	}
+	formatted.WriteByte('\n')
+	_, err = formatted.WriteTo(c.out)
+	return err
+}
+
+// call sends the request and decodes the JSON response into result
+func (c *adminClient) call(method string, path string, query url.Values, payload any, result any) error {
+	data, err := c.send(method, path, query, payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (c *adminClient) send(method string, path string, query url.Values, payload any) ([]byte, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
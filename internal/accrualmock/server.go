@@ -0,0 +1,241 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+var DefaultProgression = []entities.OrderStatus{
+	entities.OrderStatusRegistered,
+	entities.OrderStatusProcessing,
+	entities.OrderStatusProcessed,
+}
+
+type Options struct {
+	Latency       time.Duration
+	ErrorRate     float64
+	RatePerMinute int
+	RetryAfter    time.Duration
+	RewardMin     float32
+	RewardMax     float32
+	AutoRegister  bool
+}
+
+// Registration seeds an order. Statuses are returned one per request, the last one sticks.
+// Accrual is only reported with the PROCESSED status, a random reward is used if it's nil
+type Registration struct {
+	Number   string                 `json:"order"`
+	Statuses []entities.OrderStatus `json:"statuses,omitempty"`
+	Accrual  *float32               `json:"accrual,omitempty"`
+}
+
+type orderResponse struct {
+	Number  string               `json:"order"`
+	Status  entities.OrderStatus `json:"status"`
+	Accrual *float32             `json:"accrual,omitempty"`
+}
+
+type script struct {
+	statuses []entities.OrderStatus
+	accrual  float32
+	step     int
+}
+
+type Server struct {
+	logger      logging.ILogger
+	opts        Options
+	lock        sync.Mutex
+	rnd         *rand.Rand
+	orders      map[string]*script
+	windowStart time.Time
+	requests    int
+}
+
+func NewServer(logger logging.ILogger, opts Options) *Server {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Minute
+	}
+	if opts.RewardMax < opts.RewardMin {
+		opts.RewardMax = opts.RewardMin
+	}
+	return &Server{
+		logger: logger,
+		opts:   opts,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		orders: make(map[string]*script),
+	}
+}
+
+func (s *Server) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.getOrder)
+	r.Post("/api/orders", s.registerOrder)
+	r.Delete("/api/orders", s.reset)
+	return r
+}
+
+func (s *Server) Register(reg *Registration) error {
+	if reg.Number == "" {
+		return errors.New("order number is empty")
+	}
+	statuses := reg.Statuses
+	if len(statuses) == 0 {
+		statuses = DefaultProgression
+	}
+	for _, status := range statuses {
+		if status == entities.OrderStatusNew {
+			return fmt.Errorf("%w: %s", entities.ErrUnknownOrderStatus, status)
+		}
+		if _, err := entities.ParseOrderStatus(string(status)); err != nil {
+			return err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	accrual := s.reward()
+	if reg.Accrual != nil {
+		accrual = *reg.Accrual
+	}
+	s.orders[reg.Number] = &script{statuses: statuses, accrual: accrual}
+	return nil
+}
+
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orders = make(map[string]*script)
+	s.windowStart = time.Time{}
+	s.requests = 0
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	if !s.sleep(r) {
+		return
+	}
+	if retryAfter, limited := s.limit(); limited {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(fmt.Sprintf("No more than %d requests per minute allowed", s.opts.RatePerMinute)))
+		return
+	}
+	if s.fail() {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Injected failure"))
+		return
+	}
+	resp := s.advance(chi.URLParam(r, "number"))
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal response"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *Server) registerOrder(w http.ResponseWriter, r *http.Request) {
+	var reg Registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse registration"))
+		return
+	}
+	if err := s.Register(&reg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.logger.Infof("Registered order %s", reg.Number)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) advance(number string) *orderResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	order, ok := s.orders[number]
+	if !ok {
+		if !s.opts.AutoRegister {
+			return nil
+		}
+		order = &script{statuses: DefaultProgression, accrual: s.reward()}
+		s.orders[number] = order
+	}
+	status := order.statuses[order.step]
+	if order.step < len(order.statuses)-1 {
+		order.step++
+	}
+	resp := &orderResponse{Number: number, Status: status}
+	if status == entities.OrderStatusProcessed {
+		accrual := order.accrual
+		resp.Accrual = &accrual
+	}
+	return resp
+}
+
+// limit counts requests in fixed one-minute windows, the same way the real accrual system does
+func (s *Server) limit() (time.Duration, bool) {
+	if s.opts.RatePerMinute <= 0 {
+		return 0, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.requests = 0
+	}
+	s.requests++
+	if s.requests <= s.opts.RatePerMinute {
+		return 0, false
+	}
+	return s.opts.RetryAfter, true
+}
+
+func (s *Server) fail() bool {
+	if s.opts.ErrorRate <= 0 {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rnd.Float64() < s.opts.ErrorRate
+}
+
+func (s *Server) sleep(r *http.Request) bool {
+	if s.opts.Latency <= 0 {
+		return true
+	}
+	s.lock.Lock()
+	delay := time.Duration(s.rnd.Int63n(int64(s.opts.Latency)))
+	s.lock.Unlock()
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+func (s *Server) reward() float32 {
+	return s.opts.RewardMin + s.rnd.Float32()*(s.opts.RewardMax-s.opts.RewardMin)
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+)
+
+func AdminMiddleware(logger logging.ILogger, token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		checkTokenFn := func(w http.ResponseWriter, r *http.Request) {
+			supplied, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+				logging.FromContext(r.Context(), logger).Warningf("Rejected an admin request: %s %s", r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Invalid admin token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(checkTokenFn)
+	}
+}
@@ -13,7 +13,7 @@ func Middleware(logger logging.ILogger, userRepo entities.UserRepo) func(next ht
 	return func(next http.Handler) http.Handler {
 		checkAuthFn := func(w http.ResponseWriter, r *http.Request) {
 			if r.Method == "POST" && (r.URL.Path == "/api/user/register" || r.URL.Path == "/api/user/login") {
-				logger.Infoln("No auth check necessary")
+				logging.FromContext(r.Context(), logger).Debugf("No auth check necessary")
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -40,6 +40,10 @@ func Middleware(logger logging.ILogger, userRepo entities.UserRepo) func(next ht
 				return
 			}
 			ctx := context.WithValue(r.Context(), entities.ContextKey{Key: "user_id"}, session.UserID)
+			if session.Partner != nil {
+				ctx = context.WithValue(ctx, entities.ContextKey{Key: "partner"}, *session.Partner)
+			}
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx, logger).WithField("user_id", session.UserID))
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(checkAuthFn)
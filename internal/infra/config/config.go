@@ -2,6 +2,8 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/caarlos0/env/v9"
@@ -10,15 +12,112 @@ import (
 const SessionTTL = 1 * time.Hour
 const MaxAccrualRequestAttempts = 5
 const DefaultAccrualRequestTimeoutSec = 10
+const AccrualRetryBaseDelay = 500 * time.Millisecond
+const AccrualRetryMaxDelay = 10 * time.Second
+
+const DefaultPageSize = 100
+const MaxPageSize = 1000
+
+const EventsReplayLimit = 1000
+const EventsSubscriberBuffer = 64
+const EventsChannel = "gophermart_events"
+const OrdersChannel = "gophermart_orders"
+const OutboxRelayInterval = 250 * time.Millisecond
+const OutboxRelayBatchSize = 100
+const ListenerRetryDelay = 5 * time.Second
+const EventsHeartbeatInterval = 15 * time.Second
+
+const WebhookInterval = 5 * time.Second
+const WebhookBatchSize = 50
+const WebhookRequestTimeout = 10 * time.Second
+const MaxWebhookAttempts = 8
+const WebhookBaseRetryDelay = 10 * time.Second
+const WebhookMaxRetryDelay = 1 * time.Hour
 
-const WorkerPoolSize = 3
-const WorkerJobsCapacity = 100
 const WorkerInterval = 15 * time.Second
+const WorkerScaleInterval = 10 * time.Second
+const OrderLease = 2 * time.Minute
+const OrderRetryBaseDelay = 5 * time.Second
+const OrderRetryMaxDelay = 10 * time.Minute
+const OrderMaxAge = 24 * time.Hour
+const ReconcileMaxOrders = 10000
+const ReconcileKeepJobs = 20
+const AccrualCacheMaxEntries = 10000
+const AccrualLookupTimeout = 1 * time.Minute
+const OrderCountInterval = 30 * time.Second
+const WorkerRestartDelay = 1 * time.Second
+const ServerShutdownTimeout = 10 * time.Second
+const ReadinessCheckTimeout = 2 * time.Second
 
 type Config struct {
 	ServerAddr  string `env:"RUN_ADDRESS"`
 	DatabaseDSN string `env:"DATABASE_URI"`
 	AccrualAddr string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+
+	OrderValidator     string `env:"ORDER_VALIDATOR" envDefault:"luhn"`
+	OrderNumberPrefix  string `env:"ORDER_NUMBER_PREFIX"`
+	OrderNumberPattern string `env:"ORDER_NUMBER_PATTERN" envDefault:"^[0-9]+$"`
+	// Partner settings are keyed by the partner users are assigned to. Patterns are separated by semicolons
+	// since regular expressions often contain commas, e.g. PARTNER_ORDER_PATTERNS="acme:^[0-9]{8,10}$;globex:^X+$"
+	PartnerValidators map[string]string `env:"PARTNER_ORDER_VALIDATORS"`
+	PartnerPrefixes   map[string]string `env:"PARTNER_ORDER_PREFIXES"`
+	PartnerPatterns   map[string]string `env:"PARTNER_ORDER_PATTERNS" envSeparator:";"`
+
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"info"`
+	LogFormat string `env:"LOG_FORMAT" envDefault:"json"`
+	// MetricsAddr serves /metrics on a separate listener, which should not be publicly reachable.
+	// Otherwise /metrics is mounted on the main router and requires the admin token
+	MetricsAddr string `env:"METRICS_ADDRESS"`
+
+	// TracingExporter is one of none, otlp, stdout or file. OTLP honours the standard OTEL_EXPORTER_OTLP_* variables
+	TracingExporter    string `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingFile        string `env:"TRACING_FILE" envDefault:"traces.jsonl"`
+	TracingServiceName string `env:"TRACING_SERVICE_NAME" envDefault:"gophermart"`
+
+	// ShutdownReadinessDelay gives the orchestrator time to notice /readyz failing before the listener closes
+	ShutdownReadinessDelay time.Duration `env:"SHUTDOWN_READINESS_DELAY" envDefault:"5s"`
+
+	WorkerDrainTimeout  time.Duration `env:"WORKER_DRAIN_TIMEOUT" envDefault:"30s"`
+	WorkerPoolMin       int           `env:"WORKER_POOL_MIN" envDefault:"3"`
+	WorkerPoolMax       int           `env:"WORKER_POOL_MAX" envDefault:"16"`
+	WorkerQueueCapacity int           `env:"WORKER_QUEUE_CAPACITY" envDefault:"100"`
+	WorkerMaxLatency    time.Duration `env:"WORKER_MAX_LATENCY" envDefault:"2s"`
+
+	OrderMaxFailures int `env:"ORDER_MAX_FAILURES" envDefault:"10"`
+
+	// Reconciliations get their own accrual rate limit so that they don't compete with order processing
+	ReconcileRatePerMinute int `env:"RECONCILE_RATE_PER_MINUTE" envDefault:"60"`
+	ReconcileRateBurst     int `env:"RECONCILE_RATE_BURST" envDefault:"1"`
+
+	AccrualProvidersFile  string        `env:"ACCRUAL_PROVIDERS_FILE"`
+	AccrualRequestTimeout time.Duration `env:"ACCRUAL_REQUEST_TIMEOUT" envDefault:"5s"`
+	AccrualCacheTTL       time.Duration `env:"ACCRUAL_CACHE_TTL" envDefault:"2s"`
+	AccrualRatePerMinute  int           `env:"ACCRUAL_RATE_PER_MINUTE" envDefault:"0"`
+	AccrualRateBurst      int           `env:"ACCRUAL_RATE_BURST" envDefault:"10"`
+
+	AccrualMaxIdleConns          int           `env:"ACCRUAL_MAX_IDLE_CONNS" envDefault:"100"`
+	AccrualMaxIdleConnsPerHost   int           `env:"ACCRUAL_MAX_IDLE_CONNS_PER_HOST" envDefault:"16"`
+	AccrualIdleConnTimeout       time.Duration `env:"ACCRUAL_IDLE_CONN_TIMEOUT" envDefault:"90s"`
+	AccrualDialTimeout           time.Duration `env:"ACCRUAL_DIAL_TIMEOUT" envDefault:"5s"`
+	AccrualKeepAlive             time.Duration `env:"ACCRUAL_KEEP_ALIVE" envDefault:"30s"`
+	AccrualTLSHandshakeTimeout   time.Duration `env:"ACCRUAL_TLS_HANDSHAKE_TIMEOUT" envDefault:"5s"`
+	AccrualResponseHeaderTimeout time.Duration `env:"ACCRUAL_RESPONSE_HEADER_TIMEOUT" envDefault:"5s"`
+	AccrualHTTP2                 bool          `env:"ACCRUAL_HTTP2" envDefault:"false"`
+
+	BreakerFailureThreshold int           `env:"ACCRUAL_BREAKER_FAILURES" envDefault:"5"`
+	BreakerOpenTimeout      time.Duration `env:"ACCRUAL_BREAKER_OPEN_TIMEOUT" envDefault:"30s"`
+	BreakerHalfOpenCalls    int           `env:"ACCRUAL_BREAKER_HALF_OPEN_CALLS" envDefault:"1"`
+}
+
+// InstanceID identifies this process as the owner of claimed orders
+func InstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
 
 func Read() (*Config, error) {
@@ -40,7 +139,7 @@ func Read() (*Config, error) {
 	if conf.AccrualAddr == "" {
 		conf.AccrualAddr = *flagAccrualAddr
 	}
-	if conf.ServerAddr == "" || conf.DatabaseDSN == "" || conf.AccrualAddr == "" {
+	if conf.ServerAddr == "" || conf.DatabaseDSN == "" || (conf.AccrualAddr == "" && conf.AccrualProvidersFile == "") {
 		panic("Invalid server configuration")
 	}
 	return conf, nil
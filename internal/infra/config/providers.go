@@ -0,0 +1,129 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const DefaultAccrualProvider = "default"
+
+const (
+	ProviderKindHTTP = "http"
+	ProviderKindGRPC = "grpc"
+)
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// ProviderConfig describes one accrual system. Credentials are sent as HTTP headers or gRPC metadata
+type ProviderConfig struct {
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind"`
+	Address       string            `json:"address"`
+	Credentials   map[string]string `json:"credentials"`
+	Timeout       Duration          `json:"timeout"`
+	RatePerMinute int               `json:"rate_per_minute"`
+	RateBurst     int               `json:"rate_burst"`
+	MaxAttempts   int               `json:"max_attempts"`
+	Insecure      bool              `json:"insecure"`
+}
+
+// RouteConfig sends orders to a provider. Every non-empty condition has to match, the first matching route wins.
+// Partner is the one the authenticated user is assigned to
+type RouteConfig struct {
+	Provider string `json:"provider"`
+	Prefix   string `json:"prefix"`
+	Partner  string `json:"partner"`
+}
+
+type ProvidersConfig struct {
+	Default   string           `json:"default"`
+	Providers []ProviderConfig `json:"providers"`
+	Routes    []RouteConfig    `json:"routes"`
+}
+
+// ReadProviders loads the accrual providers file. Without a file the single accrual system
+// from ACCRUAL_SYSTEM_ADDRESS is used for all orders
+func ReadProviders(conf *Config) (*ProvidersConfig, error) {
+	providers := &ProvidersConfig{}
+	if conf.AccrualProvidersFile != "" {
+		data, err := os.ReadFile(conf.AccrualProvidersFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, providers); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", conf.AccrualProvidersFile, err)
+		}
+	}
+	if len(providers.Providers) == 0 {
+		providers.Providers = []ProviderConfig{{Name: DefaultAccrualProvider, Kind: ProviderKindHTTP}}
+	}
+	if providers.Default == "" {
+		providers.Default = providers.Providers[0].Name
+	}
+	names := make(map[string]bool, len(providers.Providers))
+	for i := range providers.Providers {
+		provider := &providers.Providers[i]
+		if provider.Name == "" || names[provider.Name] {
+			return nil, fmt.Errorf("accrual provider names must be unique and non-empty: %q", provider.Name)
+		}
+		names[provider.Name] = true
+		provider.applyDefaults(conf)
+		if provider.Kind != ProviderKindHTTP && provider.Kind != ProviderKindGRPC {
+			return nil, fmt.Errorf("unknown kind of accrual provider %s: %q", provider.Name, provider.Kind)
+		}
+		if provider.Address == "" {
+			return nil, fmt.Errorf("accrual provider %s has no address", provider.Name)
+		}
+	}
+	if !names[providers.Default] {
+		return nil, fmt.Errorf("unknown default accrual provider: %q", providers.Default)
+	}
+	for _, route := range providers.Routes {
+		if !names[route.Provider] {
+			return nil, fmt.Errorf("route to unknown accrual provider: %q", route.Provider)
+		}
+		if route.Prefix == "" && route.Partner == "" {
+			return nil, errors.New("accrual provider routes need a prefix or partner")
+		}
+	}
+	return providers, nil
+}
+
+func (p *ProviderConfig) applyDefaults(conf *Config) {
+	if p.Kind == "" {
+		p.Kind = ProviderKindHTTP
+	}
+	if p.Address == "" && p.Name == DefaultAccrualProvider {
+		p.Address = conf.AccrualAddr
+	}
+	if p.Timeout.Duration <= 0 {
+		p.Timeout.Duration = conf.AccrualRequestTimeout
+	}
+	if p.RatePerMinute == 0 {
+		p.RatePerMinute = conf.AccrualRatePerMinute
+	}
+	if p.RateBurst <= 0 {
+		p.RateBurst = conf.AccrualRateBurst
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = MaxAccrualRequestAttempts
+	}
+}
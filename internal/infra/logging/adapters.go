@@ -1,14 +1,46 @@
 package logging
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/sirupsen/logrus"
 )
 
-func SetupLogger() *logrus.Logger {
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Logger adapts a logrus entry to ILogger, so that derived loggers keep their fields
+type Logger struct {
+	*logrus.Entry
+}
+
+func SetupLogger(level string, format string) (*Logger, error) {
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.DebugLevel)
-	return logger
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(parsed)
+	switch format {
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		logger.SetFormatter(&logrus.TextFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", format)
+	}
+	logger.AddHook(redactHook{})
+	return &Logger{Entry: logrus.NewEntry(logger)}, nil
+}
+
+func (l *Logger) WithField(key string, value interface{}) ILogger {
+	return &Logger{Entry: l.Entry.WithField(key, value)}
+}
+
+func (l *Logger) WithFields(fields Fields) ILogger {
+	return &Logger{Entry: l.Entry.WithFields(logrus.Fields(fields))}
 }
@@ -0,0 +1,25 @@
+package logging
+
+import "context"
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger stores a logger carrying request or job fields in the context
+func WithLogger(ctx context.Context, logger ILogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored by WithLogger, or the fallback if there is none
+func FromContext(ctx context.Context, fallback ILogger) ILogger {
+	if logger, ok := ctx.Value(loggerKey{}).(ILogger); ok {
+		return logger
+	}
+	return fallback
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
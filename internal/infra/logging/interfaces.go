@@ -1,8 +1,13 @@
 package logging
 
+type Fields map[string]interface{}
+
 type ILogger interface {
+	Debugf(format string, args ...interface{})
 	Infof(format string, args ...interface{})
 	Infoln(args ...interface{})
 	Errorf(format string, args ...interface{})
 	Warningf(format string, args ...interface{})
+	WithField(key string, value interface{}) ILogger
+	WithFields(fields Fields) ILogger
 }
@@ -1,7 +1,11 @@
 package logging
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -26,7 +30,57 @@ func (w *extendedWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
-func Middleware(logger ILogger) func(next http.Handler) http.Handler {
+func (w *extendedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware reuses the caller's X-Request-ID or generates one, echoes it in the response
+// and makes it available via RequestID
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware puts a request-scoped logger into the context and writes an access log line.
+// Successful requests to the quiet paths, such as health probes, are only logged at debug level
+func Middleware(logger ILogger, quietPaths ...string) func(next http.Handler) http.Handler {
+	quiet := make(map[string]bool, len(quietPaths))
+	for _, path := range quietPaths {
+		quiet[path] = true
+	}
 	return func(next http.Handler) http.Handler {
 		logFn := func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -37,12 +91,22 @@ func Middleware(logger ILogger) func(next http.Handler) http.Handler {
 					size:   0,
 				},
 			}
-			next.ServeHTTP(extWriter, r)
-			duration := time.Since(start)
-			logger.Infof("Served: %s %s, %v\n", r.Method, r.RequestURI, duration)
-			logger.Infof(
-				"Response: [%d] %d bytes \n", extWriter.response.status, extWriter.response.size,
-			)
+			reqLogger := logger.WithFields(Fields{
+				"request_id": RequestID(r.Context()),
+				"method":     r.Method,
+				"path":       r.URL.Path,
+			})
+			next.ServeHTTP(extWriter, r.WithContext(WithLogger(r.Context(), reqLogger)))
+			accessLogger := reqLogger.WithFields(Fields{
+				"status":      extWriter.response.status,
+				"size":        extWriter.response.size,
+				"duration_ms": time.Since(start).Milliseconds(),
+			})
+			if quiet[r.URL.Path] && extWriter.response.status < http.StatusBadRequest {
+				accessLogger.Debugf("Served")
+				return
+			}
+			accessLogger.Infoln("Served")
 		}
 		return http.HandlerFunc(logFn)
 	}
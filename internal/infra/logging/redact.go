@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+var sensitiveFields = []string{"token", "password", "authorization", "cookie", "secret", "api_key"}
+
+var sensitivePattern = regexp.MustCompile(`(?i)(session_token=|bearer\s+)[^\s;,&"]+`)
+
+// redactHook masks secrets, e.g. session tokens, both in sensitive fields and inside messages
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = sensitivePattern.ReplaceAllString(entry.Message, "${1}"+redacted)
+	for key := range entry.Data {
+		if isSensitive(key) {
+			entry.Data[key] = redacted
+		}
+	}
+	return nil
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const countTimeout = 30 * time.Second
+
+var (
+	queueDepthDesc = prometheus.NewDesc(
+		namespace+"_job_queue_depth", "Jobs waiting in the collector queue", nil, nil,
+	)
+	queueCapacityDesc = prometheus.NewDesc(
+		namespace+"_job_queue_capacity", "Capacity of the collector queue", nil, nil,
+	)
+	collectorsDesc = prometheus.NewDesc(
+		namespace+"_collectors", "Collector workers by state: busy or idle", []string{"state"}, nil,
+	)
+	ordersDesc = prometheus.NewDesc(
+		namespace+"_orders", "Orders by status", []string{"status"}, nil,
+	)
+	breakerDesc = prometheus.NewDesc(
+		namespace+"_accrual_breaker_state",
+		"Accrual circuit breaker state by provider: 0 closed, 1 half-open, 2 open",
+		[]string{"provider"},
+		nil,
+	)
+	throttledDesc = prometheus.NewDesc(
+		namespace+"_accrual_throttled_total",
+		"Times the accrual provider asked to slow down (HTTP 429 or RESOURCE_EXHAUSTED)",
+		[]string{"provider"},
+		nil,
+	)
+)
+
+var breakerStates = map[entities.CircuitState]float64{
+	entities.CircuitClosed:   0,
+	entities.CircuitHalfOpen: 1,
+	entities.CircuitOpen:     2,
+}
+
+// OrderCounter counts orders by status in the background, since a full table scan is too costly for every scrape
+type OrderCounter struct {
+	logger    logging.ILogger
+	orderRepo entities.OrderRepo
+	tick      <-chan time.Time
+	lock      sync.Mutex
+	counts    map[entities.OrderStatus]int
+}
+
+func NewOrderCounter(logger logging.ILogger, orderRepo entities.OrderRepo, tick <-chan time.Time) *OrderCounter {
+	return &OrderCounter{logger: logger, orderRepo: orderRepo, tick: tick}
+}
+
+func (oc *OrderCounter) Run(ctx context.Context) {
+	oc.logger.Infoln("Launching the order counter")
+	oc.refresh(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			oc.logger.Infoln("Stopping the order counter")
+			return
+		case <-oc.tick:
+			oc.refresh(ctx)
+		}
+	}
+}
+
+func (oc *OrderCounter) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, countTimeout)
+	defer cancel()
+	counts, err := oc.orderRepo.CountOrdersByStatus(ctx)
+	if err != nil {
+		oc.logger.Errorf("Failed to count orders for metrics: %v", err)
+		return
+	}
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+	oc.counts = counts
+}
+
+// Counts returns the latest counts, nil until the first count succeeds
+func (oc *OrderCounter) Counts() map[entities.OrderStatus]int {
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+	return oc.counts
+}
+
+// stateCollector reads gauges from the components owning the state at scrape time
+type stateCollector struct {
+	pool      entities.ICollectorPool
+	orders    *OrderCounter
+	providers entities.IAccrualProviderRegistry
+}
+
+func RegisterStateCollectors(
+	db *sql.DB,
+	pool entities.ICollectorPool,
+	orders *OrderCounter,
+	providers entities.IAccrualProviderRegistry,
+) {
+	Registry.MustRegister(
+		collectors.NewDBStatsCollector(db, namespace),
+		&stateCollector{pool: pool, orders: orders, providers: providers},
+	)
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueCapacityDesc
+	ch <- collectorsDesc
+	ch <- ordersDesc
+	ch <- breakerDesc
+	ch <- throttledDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	pool := c.pool.State()
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(pool.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(queueCapacityDesc, prometheus.GaugeValue, float64(pool.QueueCapacity))
+	ch <- prometheus.MustNewConstMetric(collectorsDesc, prometheus.GaugeValue, float64(pool.Busy), "busy")
+	ch <- prometheus.MustNewConstMetric(collectorsDesc, prometheus.GaugeValue, float64(pool.Size-pool.Busy), "idle")
+	for _, state := range c.providers.States() {
+		ch <- prometheus.MustNewConstMetric(
+			breakerDesc, prometheus.GaugeValue, breakerStates[state.Breaker.State], state.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			throttledDesc, prometheus.CounterValue, float64(state.Limiter.Throttled), state.Name,
+		)
+	}
+	for status, count := range c.orders.Counts() {
+		ch <- prometheus.MustNewConstMetric(ordersDesc, prometheus.GaugeValue, float64(count), string(status))
+	}
+}
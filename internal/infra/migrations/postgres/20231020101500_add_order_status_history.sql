@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+create table order_status_history(
+    id integer primary key generated always as identity,
+    order_id integer references orders(id) not null,
+    status order_status not null,
+    accrual_response jsonb, -- raw accrual system response that caused the transition, null for NEW
+    changed_at timestamptz not null
+);
+create index order_status_history_idx on order_status_history(order_id, changed_at);
+-- only the upload is known for existing orders, later transitions weren't recorded
+insert into order_status_history(order_id, status, changed_at)
+select id, 'NEW', uploaded_at from orders;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index order_status_history_idx;
+drop table order_status_history;
+-- +goose StatementEnd
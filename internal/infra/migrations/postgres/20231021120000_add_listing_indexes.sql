@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+create index user_orders_keyset_idx on orders(user_id, uploaded_at, id);
+drop index user_orders_idx;
+create index user_withdrawals_keyset_idx on accruals(user_id, processed_at, id) where amount < 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index user_withdrawals_keyset_idx;
+create index user_orders_idx on orders(user_id);
+drop index user_orders_keyset_idx;
+-- +goose StatementEnd
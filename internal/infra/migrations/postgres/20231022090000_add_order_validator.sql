@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table orders add column validator text not null default 'luhn';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table orders drop column validator;
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+create type webhook_delivery_status as enum ('PENDING', 'DELIVERED', 'DEAD');
+create table webhook_subscriptions(
+    id integer primary key generated always as identity,
+    user_id integer references users(id) not null,
+    url text not null,
+    secret text not null,
+    event_types jsonb not null,
+    created_at timestamptz not null
+);
+create index user_webhook_subscriptions_idx on webhook_subscriptions(user_id);
+create table webhook_deliveries(
+    id bigint primary key generated always as identity,
+    subscription_id integer references webhook_subscriptions(id) on delete cascade not null,
+    event_type text not null,
+    payload jsonb not null,
+    status webhook_delivery_status not null,
+    attempts integer not null default 0,
+    next_attempt_at timestamptz not null,
+    last_error text,
+    created_at timestamptz not null,
+    delivered_at timestamptz
+);
+create index pending_webhook_deliveries_idx on webhook_deliveries(next_attempt_at) where status = 'PENDING';
+create index subscription_webhook_deliveries_idx on webhook_deliveries(subscription_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index subscription_webhook_deliveries_idx;
+drop index pending_webhook_deliveries_idx;
+drop table webhook_deliveries;
+drop index user_webhook_subscriptions_idx;
+drop table webhook_subscriptions;
+drop type webhook_delivery_status;
+-- +goose StatementEnd
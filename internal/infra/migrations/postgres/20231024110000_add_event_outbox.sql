@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+create table event_outbox(
+    id bigint primary key generated always as identity,
+    user_id integer references users(id) not null,
+    event_type text not null,
+    payload jsonb not null,
+    created_at timestamptz not null,
+    published_at timestamptz -- set by the relay in the same transaction that sends the notification
+);
+create index unpublished_events_idx on event_outbox(id) where published_at is null;
+create index user_events_idx on event_outbox(user_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index user_events_idx;
+drop index unpublished_events_idx;
+drop table event_outbox;
+-- +goose StatementEnd
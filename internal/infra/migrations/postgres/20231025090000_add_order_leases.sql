@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table orders add column locked_by text;
+alter table orders add column lease_until timestamptz;
+create index unprocessed_orders_idx on orders(id) where status not in ('INVALID', 'PROCESSED');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index unprocessed_orders_idx;
+alter table orders drop column lease_until;
+alter table orders drop column locked_by;
+-- +goose StatementEnd
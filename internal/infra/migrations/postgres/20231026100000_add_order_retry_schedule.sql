@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table orders add column attempts integer not null default 0;
+alter table orders add column next_attempt_at timestamptz not null default now();
+alter table orders add column stuck_at timestamptz;
+drop index unprocessed_orders_idx;
+create index due_orders_idx on orders(next_attempt_at)
+    where status not in ('INVALID', 'PROCESSED') and stuck_at is null;
+create index stuck_orders_idx on orders(stuck_at) where stuck_at is not null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index stuck_orders_idx;
+drop index due_orders_idx;
+create index unprocessed_orders_idx on orders(id) where status not in ('INVALID', 'PROCESSED');
+alter table orders drop column stuck_at;
+alter table orders drop column next_attempt_at;
+alter table orders drop column attempts;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+create table order_failures (
+    id integer primary key generated always as identity,
+    order_id integer not null references orders(id) on delete cascade,
+    reason text not null,
+    failed_at timestamptz not null default now()
+);
+create index order_failures_idx on order_failures(order_id, failed_at);
+alter table orders add column failures integer not null default 0;
+alter table orders add column last_error text;
+alter table orders add column dead_at timestamptz;
+alter table orders add column force_repoll boolean not null default false;
+alter table orders add column requeued_at timestamptz;
+drop index due_orders_idx;
+create index due_orders_idx on orders(next_attempt_at)
+    where (status not in ('INVALID', 'PROCESSED') or force_repoll) and stuck_at is null and dead_at is null;
+create index failed_orders_idx on orders(id) where failures > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index failed_orders_idx;
+drop index due_orders_idx;
+create index due_orders_idx on orders(next_attempt_at)
+    where status not in ('INVALID', 'PROCESSED') and stuck_at is null;
+alter table orders drop column requeued_at;
+alter table orders drop column force_repoll;
+alter table orders drop column dead_at;
+alter table orders drop column last_error;
+alter table orders drop column failures;
+drop table order_failures;
+-- +goose StatementEnd
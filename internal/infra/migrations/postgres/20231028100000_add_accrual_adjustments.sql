@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+create table accrual_adjustments (
+    id integer primary key generated always as identity,
+    user_id integer references users(id) not null,
+    order_number text not null,
+    old_status order_status not null,
+    new_status order_status not null,
+    old_amount float,
+    new_amount float not null,
+    reason text not null,
+    created_at timestamptz not null default now()
+);
+create index accrual_adjustments_idx on accrual_adjustments(order_number, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table accrual_adjustments;
+-- +goose StatementEnd
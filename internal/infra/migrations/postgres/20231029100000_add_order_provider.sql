@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table orders add column provider text;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table orders drop column provider;
+-- +goose StatementEnd
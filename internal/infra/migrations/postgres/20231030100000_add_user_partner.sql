@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table users add column partner text;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column partner;
+-- +goose StatementEnd
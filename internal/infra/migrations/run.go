@@ -1,7 +1,11 @@
 package migrations
 
 import (
+	"context"
+	"database/sql"
 	"embed"
+	"fmt"
+	"io/fs"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pressly/goose/v3"
@@ -19,3 +23,36 @@ func Migrate(db *sqlx.DB) {
 		panic(err)
 	}
 }
+
+// Check reports an error if the database schema is behind the embedded migrations.
+// It relies on the dialect set by Migrate
+func Check(ctx context.Context, db *sql.DB) error {
+	latest, err := latestVersion()
+	if err != nil {
+		return err
+	}
+	current, err := goose.GetDBVersionContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("database schema version %d is behind %d", current, latest)
+	}
+	return nil
+}
+
+func latestVersion() (int64, error) {
+	entries, err := fs.ReadDir(embedMigrations, "postgres")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, entry := range entries {
+		version, err := goose.NumericComponent(entry.Name())
+		if err != nil {
+			return 0, err
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
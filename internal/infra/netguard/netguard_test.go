@@ -0,0 +1,49 @@
+package netguard
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := IsPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("IsPublic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	if err := DialControl("tcp4", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("DialControl() rejected a public address: %v", err)
+	}
+	for _, address := range []string{"127.0.0.1:80", "[::1]:8080", "169.254.169.254:80", "10.0.0.1:443"} {
+		if err := DialControl("tcp", address, nil); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("DialControl(%s) error = %v, want ErrForbiddenAddress", address, err)
+		}
+	}
+}
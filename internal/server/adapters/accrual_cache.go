@@ -0,0 +1,110 @@
+package adapters
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"golang.org/x/sync/singleflight"
+)
+
+type cachedAccrual struct {
+	resp      *entities.AccrualResponse
+	expiresAt time.Time
+}
+
+// CoalescingAccrualClient lets concurrent lookups of the same order share a single request and briefly
+// remembers responses that aren't final yet, since the accrual system won't have moved on in a second or two.
+// Final responses are never cached: they are stored right away and the order isn't polled again.
+// The shared request isn't tied to any caller, so one of them giving up doesn't fail the others
+type CoalescingAccrualClient struct {
+	logger     logging.ILogger
+	client     entities.IAccrualClient
+	group      singleflight.Group
+	lock       sync.Mutex
+	cache      map[string]cachedAccrual
+	ttl        time.Duration
+	maxEntries int
+	timeout    time.Duration
+}
+
+func NewCoalescingAccrualClient(
+	logger logging.ILogger, client entities.IAccrualClient, ttl time.Duration, maxEntries int, timeout time.Duration,
+) *CoalescingAccrualClient {
+	return &CoalescingAccrualClient{
+		logger:     logger,
+		client:     client,
+		cache:      make(map[string]cachedAccrual),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		timeout:    timeout,
+	}
+}
+
+func (c *CoalescingAccrualClient) GetAccrual(
+	ctx context.Context, provider string, orderNumber string,
+) (*entities.AccrualResponse, error) {
+	key := provider + "/" + orderNumber
+	if resp, ok := c.lookup(key); ok {
+		c.logger.Infof("Using the cached accrual response for order %s", orderNumber)
+		return resp, nil
+	}
+	results := c.group.DoChan(key, func() (any, error) {
+		// keeps the trace of the first caller but not its cancellation
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+		defer cancel()
+		resp, err := c.client.GetAccrual(callCtx, provider, orderNumber)
+		if err == nil && (resp == nil || !resp.Status.IsFinal()) {
+			c.store(key, resp)
+		}
+		return resp, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Shared {
+			c.logger.Infof("Accrual request for order %s was shared with a concurrent lookup", orderNumber)
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*entities.AccrualResponse), nil
+	}
+}
+
+func (c *CoalescingAccrualClient) lookup(key string) (*entities.AccrualResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(cached.expiresAt) {
+		delete(c.cache, key)
+		return nil, false
+	}
+	return cached.resp, true
+}
+
+func (c *CoalescingAccrualClient) store(key string, resp *entities.AccrualResponse) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if len(c.cache) >= c.maxEntries {
+		for k, cached := range c.cache {
+			if now.After(cached.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= c.maxEntries {
+			return
+		}
+	}
+	c.cache[key] = cachedAccrual{resp: resp, expiresAt: now.Add(c.ttl)}
+}
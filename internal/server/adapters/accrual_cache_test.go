@@ -0,0 +1,90 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const testRoundTrip = 100 * time.Millisecond
+
+// blockingAccrualClient answers once released and remembers whether it saw its context cancelled
+type blockingAccrualClient struct {
+	calls     int32
+	started   chan struct{}
+	release   chan struct{}
+	cancelled int32
+}
+
+func (c *blockingAccrualClient) GetAccrual(
+	ctx context.Context, provider string, orderNumber string,
+) (*entities.AccrualResponse, error) {
+	atomic.AddInt32(&c.calls, 1)
+	close(c.started)
+	select {
+	case <-ctx.Done():
+		atomic.StoreInt32(&c.cancelled, 1)
+		return nil, ctx.Err()
+	case <-c.release:
+		recordRoundTrip(ctx, testRoundTrip)
+		return &entities.AccrualResponse{OrderNumber: orderNumber, Status: entities.OrderStatusProcessing}, nil
+	}
+}
+
+func TestCoalescingAccrualClientCancelledCaller(t *testing.T) {
+	upstream := &blockingAccrualClient{started: make(chan struct{}), release: make(chan struct{})}
+	client := NewCoalescingAccrualClient(testLogger(t), upstream, time.Minute, 10, time.Minute)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := client.GetAccrual(firstCtx, "", testOrder)
+		firstErr <- err
+	}()
+	<-upstream.started
+	second := make(chan *entities.AccrualResponse, 1)
+	go func() {
+		resp, _ := client.GetAccrual(context.Background(), "", testOrder)
+		second <- resp
+	}()
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller got error %v, want %v", err, context.Canceled)
+	}
+	close(upstream.release)
+	if resp := <-second; resp == nil || resp.OrderNumber != testOrder {
+		t.Fatalf("other caller got %+v, want the shared response", resp)
+	}
+	if atomic.LoadInt32(&upstream.cancelled) != 0 {
+		t.Error("the shared request was cancelled with the first caller")
+	}
+	if calls := atomic.LoadInt32(&upstream.calls); calls != 1 {
+		t.Errorf("upstream got %d requests, want 1", calls)
+	}
+}
+
+func TestCoalescingAccrualClientCacheHitLatency(t *testing.T) {
+	upstream := &blockingAccrualClient{started: make(chan struct{}), release: make(chan struct{})}
+	close(upstream.release)
+	var observed []time.Duration
+	client := &timedAccrualClient{
+		client:  NewCoalescingAccrualClient(testLogger(t), upstream, time.Minute, 10, time.Minute),
+		observe: func(latency time.Duration) { observed = append(observed, latency) },
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetAccrual(context.Background(), "", testOrder); err != nil {
+			t.Fatalf("GetAccrual() error = %v", err)
+		}
+	}
+	if len(observed) != 1 || observed[0] != testRoundTrip {
+		t.Errorf("observed latencies %v, want only the %v round-trip of the first lookup", observed, testRoundTrip)
+	}
+	if calls := atomic.LoadInt32(&upstream.calls); calls != 1 {
+		t.Errorf("upstream got %d requests, want 1", calls)
+	}
+}
@@ -2,42 +2,71 @@ package adapters
 
 import (
 	"context"
+	"errors"
+	"math"
 	"time"
 
 	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/metrics"
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
 	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Collector struct {
 	name        string
+	owner       string
+	lease       time.Duration
+	retryBase   time.Duration
+	retryMax    time.Duration
+	maxFailures int
 	client      entities.IAccrualClient
 	storage     entities.Storage
 	orderRepo   entities.OrderRepo
 	accrualRepo entities.AccrualRepo
+	webhookRepo entities.WebhookRepo
+	eventRepo   entities.EventRepo
 	logger      logging.ILogger
 	jobs        <-chan entities.Job
-	done        <-chan struct{}
+	tracker     *JobTracker
 }
 
 func NewCollector(
 	name string,
+	owner string,
+	lease time.Duration,
+	retryBase time.Duration,
+	retryMax time.Duration,
+	maxFailures int,
 	client entities.IAccrualClient,
 	storage entities.Storage,
 	orderRepo entities.OrderRepo,
 	accrualRepo entities.AccrualRepo,
+	webhookRepo entities.WebhookRepo,
+	eventRepo entities.EventRepo,
 	logger logging.ILogger,
 	jobs <-chan entities.Job,
-	done <-chan struct{},
+	tracker *JobTracker,
 ) *Collector {
 	return &Collector{
 		name:        name,
+		owner:       owner,
+		lease:       lease,
+		retryBase:   retryBase,
+		retryMax:    retryMax,
+		maxFailures: maxFailures,
 		client:      client,
 		storage:     storage,
 		orderRepo:   orderRepo,
 		accrualRepo: accrualRepo,
-		logger:      logger,
+		webhookRepo: webhookRepo,
+		eventRepo:   eventRepo,
+		logger:      logger.WithField("worker", name),
 		jobs:        jobs,
-		done:        done,
+		tracker:     tracker,
 	}
 }
 
@@ -45,92 +74,406 @@ func (c *Collector) Run(ctx context.Context) {
 	c.logger.Infof("Launching the Collector worker %s", c.name)
 	for {
 		select {
-		case <-c.done:
+		case <-ctx.Done():
 			c.logger.Infof("Stopping the Collector worker %s", c.name)
 			return
 		case job := <-c.jobs:
-			c.logger.Infof("New job for collector %s: %v", c.name, job)
-			if err := c.collect(ctx, &job); err != nil {
-				c.logger.Errorf("Collector %s job %s failed: %v", c.name, job, err)
+			jobLogger := c.logger.WithFields(logging.Fields{
+				"order":    job.OrderNumber,
+				"provider": job.Provider,
+				"attempts": job.Attempts,
+			})
+			jobLogger.Infoln("New job")
+			if err := c.process(logging.WithLogger(drainContext(ctx), jobLogger), &job); err != nil {
+				jobLogger.Errorf("Job failed: %v", err)
 			}
 		}
 	}
 }
 
-func (c *Collector) collect(ctx context.Context, job *entities.Job) error {
-	if order, err := c.orderRepo.FindOrder(ctx, job.OrderNumber); err != nil {
+func (c *Collector) process(ctx context.Context, job *entities.Job) (err error) {
+	ctx, span := c.startSpan(ctx, job)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	defer c.tracker.Done(job.OrderNumber)
+	// the lease may have expired while the job was queued and another instance may own the order by now
+	if owned, err := c.orderRepo.RenewLease(ctx, job.OrderNumber, c.owner, c.lease); err != nil {
 		return err
-	} else if order.Status == "INVALID" || order.Status == "PROCESSED" {
-		c.logger.Infof("Order %s was already fetched from the accrual service")
+	} else if !owned {
+		c.logger.Warningf("Collector %s lost the lease on order %s while it was queued", c.name, job.OrderNumber)
 		return nil
 	}
-	resp, err := c.client.GetAccrual(ctx, job.OrderNumber)
-	if err != nil {
+	defer c.release(ctx, job)
+	stopRenewing := c.keepLease(ctx, job)
+	defer stopRenewing()
+	final, err := c.collect(ctx, job)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return err
+	case errors.Is(err, entities.ErrAccrualThrottled), errors.Is(err, entities.ErrCircuitOpen):
+		c.postpone(ctx, job)
 		return err
+	case isOrderFailure(err) && c.recordFailure(ctx, job, err):
+		return err
+	}
+	if !final {
+		c.scheduleRetry(ctx, job)
+	}
+	return err
+}
+
+// startSpan begins a new trace for the job linked to the supplier's one: the hand-off goes through a queue
+// and the job may outlive the trace that scheduled it
+func (c *Collector) startSpan(ctx context.Context, job *entities.Job) (context.Context, trace.Span) {
+	scheduled := trace.SpanContextFromContext(
+		otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(job.Trace)),
+	)
+	return tracing.Tracer().Start(
+		ctx,
+		"collector.process",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.Link{SpanContext: scheduled}),
+		trace.WithAttributes(
+			attribute.String("collector.name", c.name),
+			attribute.String("order.number", job.OrderNumber),
+			attribute.Int("order.attempts", job.Attempts),
+		),
+	)
+}
+
+// isOrderFailure tells failures caused by the order itself from throttling, outages and shutdown,
+// which say nothing about the order and don't count towards the dead-letter threshold
+func isOrderFailure(err error) bool {
+	return errors.Is(err, entities.ErrAccrualRejected) || errors.Is(err, entities.ErrUnknownAccrualProvider)
+}
+
+// recordFailure stores the failure reason and reports whether the order went to the dead-letter state
+func (c *Collector) recordFailure(ctx context.Context, job *entities.Job, reason error) bool {
+	order, err := c.orderRepo.RecordFailure(ctx, job.OrderNumber, reason.Error(), c.maxFailures)
+	if err != nil {
+		c.logger.Errorf("Collector %s failed to record a failure of order %s: %v", c.name, job.OrderNumber, err)
+		return false
+	}
+	if order.DeadAt == nil {
+		return false
+	}
+	c.logger.Warningf(
+		"Order %s is dead after %d failures, operator attention required. Last error: %v",
+		job.OrderNumber,
+		order.Failures,
+		reason,
+	)
+	return true
+}
+
+// postpone retries the order once the accrual system accepts requests again without counting an attempt
+func (c *Collector) postpone(ctx context.Context, job *entities.Job) {
+	if err := c.orderRepo.PostponeAttempt(ctx, job.OrderNumber, time.Now().Add(withJitter(c.retryBase))); err != nil {
+		c.logger.Errorf("Collector %s failed to postpone order %s: %v", c.name, job.OrderNumber, err)
+	}
+}
+
+func (c *Collector) scheduleRetry(ctx context.Context, job *entities.Job) {
+	delay := withJitter(exponentialBackoff(job.Attempts+1, c.retryBase, c.retryMax))
+	if err := c.orderRepo.ScheduleNextAttempt(ctx, job.OrderNumber, time.Now().Add(delay)); err != nil {
+		c.logger.Errorf("Collector %s failed to schedule a retry of order %s: %v", c.name, job.OrderNumber, err)
+	}
+}
+
+// keepLease renews the lease on the order while the job is running, e.g. during long accrual system retries
+func (c *Collector) keepLease(ctx context.Context, job *entities.Job) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(c.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				renewed, err := c.orderRepo.RenewLease(ctx, job.OrderNumber, c.owner, c.lease)
+				if err != nil {
+					c.logger.Errorf("Collector %s failed to renew the lease on order %s: %v", c.name, job.OrderNumber, err)
+				} else if !renewed {
+					c.logger.Warningf("Collector %s lost the lease on order %s", c.name, job.OrderNumber)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+func (c *Collector) release(ctx context.Context, job *entities.Job) {
+	if err := c.orderRepo.ReleaseOrder(ctx, job.OrderNumber, c.owner); err != nil {
+		c.logger.Errorf("Collector %s failed to release order %s: %v", c.name, job.OrderNumber, err)
+	}
+}
+
+// collect fetches the order accrual and reports whether the order has reached a final status
+func (c *Collector) collect(ctx context.Context, job *entities.Job) (bool, error) {
+	order, err := c.orderRepo.FindOrder(ctx, job.OrderNumber)
+	if err != nil {
+		return false, err
+	}
+	if order.Status.IsFinal() && !order.ForceRepoll {
+		c.logger.Infof("Order %s was already fetched from the accrual service", job.OrderNumber)
+		return true, nil
+	}
+	repoll := order.Status.IsFinal()
+	resp, err := c.client.GetAccrual(ctx, job.Provider, job.OrderNumber)
+	if err != nil {
+		return false, err
+	}
+	if resp == nil {
+		c.logger.Infof("Order %s is not registered in the accrual system yet", job.OrderNumber)
+		return false, nil
 	}
 	tx, err := c.storage.Tx(ctx)
 	if err != nil {
-		return err
+		return false, err
+	}
+	var oldAmount *float32
+	if repoll {
+		if oldAmount, err = c.accrualRepo.FindAccrualAmount(ctx, tx, job.UserID, job.OrderNumber); err != nil {
+			tx.Rollback()
+			return false, err
+		}
 	}
-	defer tx.Commit()
 	if err := c.accrualRepo.CreateAccrual(ctx, tx, job.UserID, resp); err != nil {
-		defer tx.Rollback()
-		return err
+		tx.Rollback()
+		return false, err
 	}
-	if err := c.orderRepo.UpdateOrderStatus(ctx, tx, job.OrderNumber, resp.Status); err != nil {
-		defer tx.Rollback()
-		return err
+	changed, err := c.orderRepo.UpdateOrderStatus(ctx, tx, job.OrderNumber, resp.Status, resp.Raw)
+	if errors.Is(err, entities.ErrIllegalStatusTransition) {
+		tx.Rollback()
+		return c.rejectTransition(ctx, job, repoll, err)
 	}
-	return nil
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	var events []*entities.Event
+	if changed {
+		if events, err = c.buildEvents(job, resp); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	if repoll && (changed || accrualChanged(oldAmount, resp)) {
+		if events, err = c.amendAccrual(ctx, tx, job, order.Status, oldAmount, resp, events); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	for _, event := range events {
+		if err := c.eventRepo.AppendEvent(ctx, tx, event); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+		if err := c.webhookRepo.EnqueueEvent(ctx, tx, event); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	if resp.Status.IsFinal() {
+		if err := c.orderRepo.FinishRepoll(ctx, tx, job.OrderNumber); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if changed && resp.Status == entities.OrderStatusProcessed {
+		metrics.PointsAccrued.Add(float64(resp.Amount))
+	}
+	return resp.Status.IsFinal(), nil
+}
+
+// rejectTransition handles an accrual status the order can't move to. A forced re-poll ends there,
+// otherwise the order is polled again later in case the accrual system is lagging behind
+func (c *Collector) rejectTransition(ctx context.Context, job *entities.Job, repoll bool, reason error) (bool, error) {
+	c.logger.Warningf("Ignoring the accrual system response for order %s: %v", job.OrderNumber, reason)
+	if !repoll {
+		return false, nil
+	}
+	tx, err := c.storage.Tx(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := c.orderRepo.FinishRepoll(ctx, tx, job.OrderNumber); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func accrualChanged(oldAmount *float32, resp *entities.AccrualResponse) bool {
+	if resp.Status != entities.OrderStatusProcessed {
+		return false
+	}
+	return oldAmount == nil || math.Abs(float64(*oldAmount-resp.Amount)) > amountTolerance
+}
+
+// amendAccrual records what a forced re-poll changed in a final order and lets the user know about a new amount
+func (c *Collector) amendAccrual(
+	ctx context.Context,
+	tx entities.Tx,
+	job *entities.Job,
+	oldStatus entities.OrderStatus,
+	oldAmount *float32,
+	resp *entities.AccrualResponse,
+	events []*entities.Event,
+) ([]*entities.Event, error) {
+	c.logger.Warningf("Forced re-poll changed the accrual of order %s", job.OrderNumber)
+	if err := c.accrualRepo.RecordAdjustment(ctx, tx, &entities.AccrualAdjustment{
+		UserID:      job.UserID,
+		OrderNumber: job.OrderNumber,
+		OldStatus:   oldStatus,
+		NewStatus:   resp.Status,
+		OldAmount:   oldAmount,
+		NewAmount:   resp.Amount,
+		Reason:      entities.ForceRepollReason,
+	}); err != nil {
+		return nil, err
+	}
+	if len(events) > 0 || resp.Status != entities.OrderStatusProcessed {
+		return events, nil
+	}
+	event, err := entities.NewEvent(job.UserID, entities.EventAccrualCreated, &entities.AccrualPayload{
+		Number:  job.OrderNumber,
+		Accrual: resp.Amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*entities.Event{event}, nil
+}
+
+func (c *Collector) buildEvents(job *entities.Job, resp *entities.AccrualResponse) ([]*entities.Event, error) {
+	statusEvent, err := entities.NewEvent(job.UserID, entities.EventOrderStatusChanged, &entities.OrderStatusPayload{
+		Number:  job.OrderNumber,
+		Status:  resp.Status,
+		Accrual: resp.Amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != entities.OrderStatusProcessed {
+		return []*entities.Event{statusEvent}, nil
+	}
+	accrualEvent, err := entities.NewEvent(job.UserID, entities.EventAccrualCreated, &entities.AccrualPayload{
+		Number:  job.OrderNumber,
+		Accrual: resp.Amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*entities.Event{statusEvent, accrualEvent}, nil
 }
 
 type Supplier struct {
+	providers entities.IAccrualProviderRegistry
+	owner     string
+	lease     time.Duration
+	maxAge    time.Duration
 	storage   entities.Storage
 	orderRepo entities.OrderRepo
 	logger    logging.ILogger
-	jobs      chan<- entities.Job
-	done      <-chan struct{}
+	jobs      chan entities.Job
+	tracker   *JobTracker
 	tick      <-chan time.Time
+	wake      chan struct{}
 	batchSize int
 }
 
 func NewSupplier(
+	providers entities.IAccrualProviderRegistry,
+	owner string,
+	lease time.Duration,
+	maxAge time.Duration,
 	storage entities.Storage,
 	orderRepo entities.OrderRepo,
 	logger logging.ILogger,
-	jobs chan<- entities.Job,
-	done <-chan struct{},
+	jobs chan entities.Job,
+	tracker *JobTracker,
 	tick <-chan time.Time,
 	batchSize int,
 ) *Supplier {
 	return &Supplier{
+		providers: providers,
+		owner:     owner,
+		lease:     lease,
+		maxAge:    maxAge,
 		storage:   storage,
 		orderRepo: orderRepo,
-		logger:    logger,
+		logger:    logger.WithField("worker", "supplier"),
 		jobs:      jobs,
-		done:      done,
+		tracker:   tracker,
 		tick:      tick,
+		wake:      make(chan struct{}, 1),
 		batchSize: batchSize,
 	}
 }
 
+// Wake makes the Supplier look for due orders right away. Wakeups coalesce while a fetch is pending
+func (s *Supplier) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// WakeOnNotification is a notification handler waking the Supplier when another instance creates an order
+func (s *Supplier) WakeOnNotification(payload string) {
+	s.logger.Infof("Order %s was created, waking up the Supplier", payload)
+	s.Wake()
+}
+
 func (s *Supplier) Run(ctx context.Context) {
 	for {
 		select {
-		case <-s.done:
+		case <-ctx.Done():
 			s.logger.Infoln("Stopping the Supplier worker")
+			s.releaseQueued(drainContext(ctx))
 			return
 		case tick := <-s.tick:
-			s.logger.Infof("Supplier worker is ticking at %v", tick)
+			s.logger.Debugf("Supplier worker is ticking at %v", tick)
 			if err := s.supply(ctx); err != nil {
 				s.logger.Errorf("Supplier worker failed: %v", err)
 			}
+		case <-s.wake:
+			if err := s.schedule(ctx); err != nil {
+				s.logger.Errorf("Supplier worker failed: %v", err)
+			}
 		}
 	}
 }
 
 func (s *Supplier) supply(ctx context.Context) error {
-	orders, err := s.orderRepo.FetchUnprocessedOrders(ctx, s.batchSize)
+	ctx, span := tracing.Tracer().Start(ctx, "supplier.supply")
+	defer span.End()
+	if err := s.markStuck(ctx); err != nil {
+		return err
+	}
+	return s.schedule(ctx)
+}
+
+func (s *Supplier) schedule(ctx context.Context) error {
+	ctx, span := tracing.Tracer().Start(ctx, "supplier.schedule")
+	defer span.End()
+	blocked, all := s.blockedProviders()
+	if all {
+		s.logger.Warningf("Circuit breakers of all accrual providers are open, not scheduling orders")
+		return nil
+	}
+	orders, err := s.orderRepo.FetchUnprocessedOrders(ctx, s.owner, s.lease, s.batchSize, blocked)
 	if err != nil {
 		return err
 	}
@@ -140,11 +483,89 @@ func (s *Supplier) supply(ctx context.Context) error {
 	}
 	s.logger.Infof("Fetched %d orders for processing", len(orders))
 	for _, order := range orders {
-		s.jobs <- entities.Job{
+		if !s.tracker.Add(order.Number) {
+			s.logger.Infof("Order %s is already queued or in flight", order.Number)
+			s.release(ctx, order.Number)
+			continue
+		}
+		job := entities.Job{
 			UserID:      order.UserID,
 			OrderNumber: order.Number,
+			Attempts:    order.Attempts,
+			Trace:       make(map[string]string),
+		}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(job.Trace))
+		if order.Provider != nil {
+			job.Provider = *order.Provider
+		}
+		select {
+		case <-ctx.Done():
+			s.tracker.Done(order.Number)
+			s.release(drainContext(ctx), order.Number)
+			return ctx.Err()
+		case s.jobs <- job:
 		}
 	}
 	s.logger.Infoln("Orders scheduled")
 	return nil
 }
+
+// blockedProviders lists the providers with an open circuit breaker, whose orders aren't scheduled.
+// Half-open ones are scheduled again so that the probes can close the breaker.
+// The empty name stands for orders without a provider, which go to the default one
+func (s *Supplier) blockedProviders() (blocked []string, all bool) {
+	states := s.providers.States()
+	open := 0
+	for _, state := range states {
+		if state.Breaker.State != entities.CircuitOpen {
+			continue
+		}
+		open++
+		s.logger.Warningf(
+			"Circuit breaker of accrual provider %s is open since %v, not scheduling its orders",
+			state.Name,
+			state.Breaker.OpenedAt,
+		)
+		blocked = append(blocked, state.Name)
+		if state.Default {
+			blocked = append(blocked, "")
+		}
+	}
+	return blocked, len(states) > 0 && open == len(states)
+}
+
+// releaseQueued gives up the leases on jobs no collector has started, so that other instances don't have
+// to wait for them to expire
+func (s *Supplier) releaseQueued(ctx context.Context) {
+	for {
+		select {
+		case job := <-s.jobs:
+			s.release(ctx, job.OrderNumber)
+			s.tracker.Done(job.OrderNumber)
+		default:
+			return
+		}
+	}
+}
+
+func (s *Supplier) release(ctx context.Context, orderNumber string) {
+	if err := s.orderRepo.ReleaseOrder(ctx, orderNumber, s.owner); err != nil {
+		s.logger.Errorf("Failed to release order %s: %v", orderNumber, err)
+	}
+}
+
+func (s *Supplier) markStuck(ctx context.Context) error {
+	stuck, err := s.orderRepo.MarkStuckOrders(ctx, time.Now().Add(-s.maxAge))
+	if err != nil {
+		return err
+	}
+	for _, order := range stuck {
+		s.logger.Warningf(
+			"Order %s is stuck in status %s after %d attempts, operator attention required",
+			order.Number,
+			order.Status,
+			order.Attempts,
+		)
+	}
+	return nil
+}
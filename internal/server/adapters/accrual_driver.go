@@ -7,121 +7,248 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
 	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var rateLimitPattern = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+var errRetryable = errors.New("retryable accrual system failure")
+
+var transientStatuses = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
 type AccrualClient struct {
 	logger            logging.ILogger
 	baseURL           string
+	client            *http.Client
+	credentials       map[string]string
 	retryAfterDefault int
 	maxAttempts       int
+	requestTimeout    time.Duration
+	retryBase         time.Duration
+	retryMax          time.Duration
+	limiter           entities.IRateLimiter
+	breaker           entities.ICircuitBreaker
 }
 
-func NewAccrualClient(logger logging.ILogger, url string, retryAfterDefault int, maxAttempts int) *AccrualClient {
+func NewAccrualClient(
+	logger logging.ILogger,
+	url string,
+	client *http.Client,
+	credentials map[string]string,
+	retryAfterDefault int,
+	maxAttempts int,
+	requestTimeout time.Duration,
+	retryBase time.Duration,
+	retryMax time.Duration,
+	limiter entities.IRateLimiter,
+	breaker entities.ICircuitBreaker,
+) *AccrualClient {
 	return &AccrualClient{
 		logger:            logger,
 		baseURL:           url,
+		client:            client,
+		credentials:       credentials,
 		retryAfterDefault: retryAfterDefault,
 		maxAttempts:       maxAttempts,
+		requestTimeout:    requestTimeout,
+		retryBase:         retryBase,
+		retryMax:          retryMax,
+		limiter:           limiter,
+		breaker:           breaker,
 	}
 }
 
 func (ac *AccrualClient) GetAccrual(ctx context.Context, orderNumber string) (*entities.AccrualResponse, error) {
+	return callThroughBreaker(ctx, ac.breaker, ac.logger, orderNumber, ac.getAccrual)
+}
+
+func (ac *AccrualClient) getAccrual(ctx context.Context, orderNumber string) (*entities.AccrualResponse, error) {
 	ac.logger.Infof("Sending request for order accrual: %s", orderNumber)
-	client := &http.Client{}
-	req, err := ac.constructRequest(ctx, orderNumber)
-	if err != nil {
-		return nil, err
-	}
+	var lastErr error
 	for i := 1; i <= ac.maxAttempts; i++ {
-		ac.logger.Infof("Accrual system request: %s. Attempt: %d", req.URL.String(), i)
-		resp, err := client.Do(req)
-		if err != nil {
-			ac.logger.Errorf("Request failed: %v", err.Error())
-			return nil, err
-		}
-		if err := ac.checkResponseCode(resp); err != nil {
-			return nil, err
-		}
-		if resp.StatusCode == http.StatusOK {
-			return ac.parseAccrualResponse(resp)
+		if err := ac.limiter.Wait(ctx); err != nil {
+			return nil, errors.New("request aborted")
 		}
-		if resp.StatusCode == http.StatusNoContent {
-			ac.logger.Infoln("Status no content")
-			return nil, nil
+		accrual, delay, err := ac.attempt(ctx, orderNumber, i)
+		if !errors.Is(err, errRetryable) {
+			return accrual, err
 		}
-		timeout, err := ac.parseRetryAfterHeader(resp)
-		if err != nil {
-			return nil, err
+		lastErr = err
+		if i == ac.maxAttempts || delay == 0 {
+			continue
 		}
+		ac.logger.Warningf("Retrying after %v", delay)
 		select {
 		case <-ctx.Done():
 			return nil, errors.New("request aborted")
-		case <-time.After(timeout):
-			ac.logger.Infof("It's time to retry the request")
+		case <-time.After(delay):
+			ac.logger.Debugf("It's time to retry the request")
 		}
 	}
 	ac.logger.Errorf("Failed to get data from the accrual system")
-	return nil, errors.New("no accrual system response")
+	return nil, exhaustedError(lastErr)
+}
+
+// exhaustedError tells throttling apart from the accrual system being down once all attempts are used
+func exhaustedError(lastErr error) error {
+	if errors.Is(lastErr, entities.ErrAccrualThrottled) {
+		return entities.ErrAccrualThrottled
+	}
+	return fmt.Errorf("%w: no response", entities.ErrAccrualUnavailable)
+}
+
+// attempt sends a single request bounded by the per-request timeout. Retryable failures are reported
+// with errRetryable and the delay to wait before the next attempt (zero if the rate limiter takes care of it).
+func (ac *AccrualClient) attempt(
+	ctx context.Context, orderNumber string, attempt int,
+) (*entities.AccrualResponse, time.Duration, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, ac.requestTimeout)
+	defer cancel()
+	reqCtx, span := tracing.Tracer().Start(reqCtx, "GET /api/orders/{number}", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(attribute.Int("accrual.attempt", attempt))
+	req, err := ac.constructRequest(reqCtx, orderNumber)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", entities.ErrAccrualRejected, err)
+	}
+	otel.GetTextMapPropagator().Inject(reqCtx, propagation.HeaderCarrier(req.Header))
+	ac.logger.Infof("Accrual system request: %s. Attempt: %d", req.URL.String(), attempt)
+	start := time.Now()
+	resp, err := ac.client.Do(req)
+	recordRoundTrip(ctx, time.Since(start))
+	if err != nil {
+		tracing.RecordError(span, err)
+		ac.logger.Errorf("Request failed: %v", err.Error())
+		if ctx.Err() != nil {
+			return nil, 0, errors.New("request aborted")
+		}
+		return nil, ac.backoff(attempt), fmt.Errorf("%w: %v", errRetryable, err)
+	}
+	defer drainBody(resp.Body)
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		accrual, err := ac.parseAccrualResponse(resp)
+		return accrual, 0, err
+	case resp.StatusCode == http.StatusNoContent:
+		ac.logger.Infoln("Status no content")
+		return nil, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		ac.logger.Infoln("Too many requests, need to wait for a while")
+		delay := ac.parseRetryAfterHeader(resp, time.Duration(ac.retryAfterDefault)*time.Second)
+		ac.adaptRate(resp)
+		ac.limiter.Pause(delay)
+		return nil, 0, fmt.Errorf("%w: %w", errRetryable, entities.ErrAccrualThrottled)
+	case transientStatuses[resp.StatusCode]:
+		ac.logger.Warningf("Transient failure of the accrual system: %d", resp.StatusCode)
+		delay := ac.parseRetryAfterHeader(resp, ac.backoff(attempt))
+		return nil, delay, fmt.Errorf("%w: status %d", errRetryable, resp.StatusCode)
+	default:
+		ac.logger.Errorf("Non-OK and non-retriable response from the accrual system: %d", resp.StatusCode)
+		return nil, 0, fmt.Errorf("%w: status %d", entities.ErrAccrualRejected, resp.StatusCode)
+	}
+}
+
+func (ac *AccrualClient) backoff(attempt int) time.Duration {
+	return withJitter(exponentialBackoff(attempt, ac.retryBase, ac.retryMax))
 }
 
 func (ac *AccrualClient) constructRequest(ctx context.Context, orderNumber string) (*http.Request, error) {
 	path := fmt.Sprintf("%s%s/%s", ac.baseURL, "/api/orders", orderNumber)
-	req, err := http.NewRequest("GET", path, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
 	if err != nil {
 		ac.logger.Errorf("Failed to construct a request: %s", err.Error())
 		return nil, err
 	}
-	req = req.WithContext(ctx)
-	return req, nil
-}
-
-func (ac *AccrualClient) checkResponseCode(resp *http.Response) error {
-	if resp.StatusCode != http.StatusOK &&
-		resp.StatusCode != http.StatusTooManyRequests &&
-		resp.StatusCode != http.StatusNoContent {
-		ac.logger.Errorf("Non-OK and non-retriable response from the accrual system: %d", resp.StatusCode)
-		return errors.New("accrual request failed")
+	for header, value := range ac.credentials {
+		req.Header.Set(header, value)
 	}
-	return nil
+	return req, nil
 }
 
 func (ac *AccrualClient) parseAccrualResponse(resp *http.Response) (*entities.AccrualResponse, error) {
 	ac.logger.Infoln("Status OK")
-	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		ac.logger.Errorf("Failed to read response body: %s", err.Error())
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", entities.ErrAccrualUnavailable, err)
 	}
 	var accrual entities.AccrualResponse
 	if err := json.Unmarshal(body, &accrual); err != nil {
 		ac.logger.Errorf("Failed to parse response: %s", err.Error())
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", entities.ErrAccrualRejected, err)
 	}
-	ac.logger.Infof("Got accrual data for order: %v", accrual)
+	accrual.Raw = body
+	ac.logger.Infof("Got accrual data for order %s: %s", accrual.OrderNumber, body)
 	return &accrual, nil
 }
 
-func (ac *AccrualClient) parseRetryAfterHeader(resp *http.Response) (time.Duration, error) {
-	ac.logger.Infoln("Too many requests, need to wait for a while")
-	var (
-		retryAfterDuration int
-		err                error
-	)
-	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-		retryAfterDuration, err = strconv.Atoi(retryAfter)
-		if err != nil {
-			ac.logger.Errorf("Invalid Retry-After header value: %s", retryAfter)
-			return 0, errors.New("invalid Retry-After header")
+func (ac *AccrualClient) parseRetryAfterHeader(resp *http.Response, fallback time.Duration) time.Duration {
+	retryAfter := resp.Header.Get("Retry-After")
+	if retryAfter == "" {
+		return fallback
+	}
+	delay, err := parseRetryAfter(retryAfter, time.Now())
+	if err != nil {
+		ac.logger.Errorf("Invalid Retry-After header value: %s, waiting for %v", retryAfter, fallback)
+		return fallback
+	}
+	return delay
+}
+
+// parseRetryAfter accepts both forms of RFC 9110 Retry-After: delay-seconds and an HTTP-date
+func parseRetryAfter(value string, now time.Time) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, errors.New("negative Retry-After delay")
 		}
-	} else {
-		retryAfterDuration = ac.retryAfterDefault
+		return time.Duration(seconds) * time.Second, nil
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, err
+	}
+	if delay := at.Sub(now); delay > 0 {
+		return delay, nil
+	}
+	return 0, nil
+}
+
+// adaptRate reads the 429 body of the accrual system and slows down all workers to the advertised limit
+func (ac *AccrualClient) adaptRate(resp *http.Response) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ac.logger.Errorf("Failed to read the rate limit response: %v", err)
+		return
+	}
+	match := rateLimitPattern.FindSubmatch(body)
+	if match == nil {
+		return
+	}
+	perMinute, err := strconv.Atoi(string(match[1]))
+	if err != nil || perMinute <= 0 {
+		return
 	}
-	ac.logger.Warningf("Retrying after %d seconds", retryAfterDuration)
-	return time.Duration(retryAfterDuration) * time.Second, nil
+	ac.logger.Warningf("Accrual system allows %d requests per minute, adjusting the rate limit", perMinute)
+	ac.limiter.SetRate(perMinute)
 }
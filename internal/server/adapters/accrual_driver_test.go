@@ -0,0 +1,321 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/accrualmock"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const testOrder = "12345678903"
+
+// pauseRecorder is a rate limiter that never waits and remembers the pauses requested by the client
+type pauseRecorder struct {
+	mu     sync.Mutex
+	pauses []time.Duration
+	rates  []int
+}
+
+func (l *pauseRecorder) Wait(ctx context.Context) error { return ctx.Err() }
+
+func (l *pauseRecorder) Pause(duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pauses = append(l.pauses, duration)
+}
+
+func (l *pauseRecorder) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rates = append(l.rates, perMinute)
+}
+
+func (l *pauseRecorder) Configure(perMinute int, burst int) {}
+
+func (l *pauseRecorder) State() entities.RateLimiterState { return entities.RateLimiterState{} }
+
+func testLogger(t testing.TB) logging.ILogger {
+	logger, err := logging.SetupLogger("panic", logging.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+func newTestAccrualClient(
+	t testing.TB, url string, maxAttempts int, timeout time.Duration, limiter entities.IRateLimiter,
+) *AccrualClient {
+	logger := testLogger(t)
+	return NewAccrualClient(
+		logger, url, &http.Client{}, nil, 7, maxAttempts, timeout, time.Millisecond, 5*time.Millisecond,
+		limiter, NewCircuitBreaker(logger, 100, time.Minute, 1),
+	)
+}
+
+// scriptedServer answers with the given statuses in turn and repeats the last one after that
+func scriptedServer(t *testing.T, statuses []int, headers map[string]string) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		for header, value := range headers {
+			w.Header().Set(header, value)
+		}
+		w.WriteHeader(statuses[i])
+		if statuses[i] == http.StatusOK {
+			w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 10, 20, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"delta seconds", "120", 2 * time.Minute, false},
+		{"zero seconds", "0", 0, false},
+		{"negative seconds", "-5", 0, true},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, false},
+		{"past http date", now.Add(-time.Hour).Format(http.TimeFormat), 0, false},
+		{"garbage", "soon", 0, true},
+		{"fractional seconds", "1.5", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRetryAfter(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRetryAfter(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAccrualRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantMin    time.Duration
+		wantMax    time.Duration
+	}{
+		{"delta seconds", "30", 30 * time.Second, 30 * time.Second},
+		{"http date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"past http date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+		{"garbage falls back to the default", "later", 7 * time.Second, 7 * time.Second},
+		{"missing falls back to the default", "", 7 * time.Second, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.retryAfter != "" {
+				headers["Retry-After"] = tt.retryAfter
+			}
+			srv, calls := scriptedServer(t, []int{http.StatusTooManyRequests, http.StatusOK}, headers)
+			limiter := &pauseRecorder{}
+			client := newTestAccrualClient(t, srv.URL, 3, time.Second, limiter)
+			resp, err := client.GetAccrual(context.Background(), testOrder)
+			if err != nil {
+				t.Fatalf("GetAccrual() error = %v", err)
+			}
+			if resp == nil || resp.Status != "PROCESSED" {
+				t.Fatalf("GetAccrual() = %+v, want a PROCESSED accrual", resp)
+			}
+			if *calls != 2 {
+				t.Errorf("server got %d requests, want 2", *calls)
+			}
+			if len(limiter.pauses) != 1 {
+				t.Fatalf("limiter paused %d times, want 1", len(limiter.pauses))
+			}
+			if pause := limiter.pauses[0]; pause < tt.wantMin || pause > tt.wantMax {
+				t.Errorf("limiter paused for %v, want [%v, %v]", pause, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestGetAccrualRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		wantCalls int32
+		wantErr   error
+		wantResp  bool
+	}{
+		{"ok", []int{http.StatusOK}, 3, 1, nil, true},
+		{"no content", []int{http.StatusNoContent}, 3, 1, nil, false},
+		{"500 then ok", []int{http.StatusInternalServerError, http.StatusOK}, 3, 2, nil, true},
+		{"502 then ok", []int{http.StatusBadGateway, http.StatusOK}, 3, 2, nil, true},
+		{"503 twice then ok", []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}, 3, 3, nil, true},
+		{"504 then ok", []int{http.StatusGatewayTimeout, http.StatusOK}, 3, 2, nil, true},
+		{"retries stop at the bound", []int{http.StatusServiceUnavailable}, 3, 3, entities.ErrAccrualUnavailable, false},
+		{"single attempt", []int{http.StatusBadGateway, http.StatusOK}, 1, 1, entities.ErrAccrualUnavailable, false},
+		{"throttled until the bound", []int{http.StatusTooManyRequests}, 2, 2, entities.ErrAccrualThrottled, false},
+		{"not retried on 400", []int{http.StatusBadRequest, http.StatusOK}, 3, 1, entities.ErrAccrualRejected, false},
+		{"not retried on 404", []int{http.StatusNotFound, http.StatusOK}, 3, 1, entities.ErrAccrualRejected, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := scriptedServer(t, tt.statuses, map[string]string{"Retry-After": "0"})
+			client := newTestAccrualClient(t, srv.URL, tt.attempts, time.Second, &pauseRecorder{})
+			resp, err := client.GetAccrual(context.Background(), testOrder)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetAccrual() error = %v, want %v", err, tt.wantErr)
+			}
+			if (resp != nil) != tt.wantResp {
+				t.Errorf("GetAccrual() = %+v, want response %v", resp, tt.wantResp)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("server got %d requests, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGetAccrualRoundTrips(t *testing.T) {
+	srv, _ := scriptedServer(
+		t, []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}, map[string]string{"Retry-After": "1"},
+	)
+	client := newTestAccrualClient(t, srv.URL, 3, time.Second, &pauseRecorder{})
+	ctx, trips := withRoundTrips(context.Background())
+	start := time.Now()
+	if _, err := client.GetAccrual(ctx, testOrder); err != nil {
+		t.Fatalf("GetAccrual() error = %v", err)
+	}
+	if count := trips.count.Load(); count != 3 {
+		t.Errorf("recorded %d round-trips, want 3", count)
+	}
+	// the second attempt waits a second for Retry-After, which isn't part of the round-trips
+	if total, elapsed := time.Duration(trips.total.Load()), time.Since(start); total > elapsed-time.Second {
+		t.Errorf("round-trips took %v of %v, want the Retry-After delay excluded", total, elapsed)
+	}
+}
+
+func TestGetAccrualInvalidBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer srv.Close()
+	client := newTestAccrualClient(t, srv.URL, 3, time.Second, &pauseRecorder{})
+	if _, err := client.GetAccrual(context.Background(), testOrder); !errors.Is(err, entities.ErrAccrualRejected) {
+		t.Fatalf("GetAccrual() error = %v, want %v", err, entities.ErrAccrualRejected)
+	}
+	if state := client.breaker.State(); state.Failures != 0 {
+		t.Errorf("breaker counted %d failures for an invalid body, want 0", state.Failures)
+	}
+}
+
+func TestGetAccrualNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	client := newTestAccrualClient(t, url, 2, time.Second, &pauseRecorder{})
+	if _, err := client.GetAccrual(context.Background(), testOrder); !errors.Is(err, entities.ErrAccrualUnavailable) {
+		t.Fatalf("GetAccrual() error = %v, want %v", err, entities.ErrAccrualUnavailable)
+	}
+	if state := client.breaker.State(); state.Failures != 1 {
+		t.Errorf("breaker counted %d failures, want 1", state.Failures)
+	}
+}
+
+func TestGetAccrualRequestTimeout(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSING"}`))
+	}))
+	defer srv.Close()
+	client := newTestAccrualClient(t, srv.URL, 2, 50*time.Millisecond, &pauseRecorder{})
+	start := time.Now()
+	resp, err := client.GetAccrual(context.Background(), testOrder)
+	if err != nil {
+		t.Fatalf("GetAccrual() error = %v", err)
+	}
+	if resp == nil || resp.Status != "PROCESSING" {
+		t.Fatalf("GetAccrual() = %+v, want a PROCESSING accrual", resp)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetAccrual() took %v, the per-request timeout wasn't applied", elapsed)
+	}
+	if calls != 2 {
+		t.Errorf("server got %d requests, want 2", calls)
+	}
+}
+
+func TestGetAccrualCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	client := newTestAccrualClient(t, srv.URL, 3, time.Second, &pauseRecorder{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetAccrual(ctx, testOrder); err == nil {
+		t.Fatal("GetAccrual() succeeded after the context was cancelled")
+	}
+	if state := client.breaker.State(); state.Failures != 0 {
+		t.Errorf("breaker counted %d failures for a cancelled call, want 0", state.Failures)
+	}
+}
+
+func BenchmarkGetAccrual(b *testing.B) {
+	logger := testLogger(b)
+	mock := accrualmock.NewServer(logger, accrualmock.Options{})
+	if err := mock.Register(&accrualmock.Registration{
+		Number: testOrder, Statuses: []entities.OrderStatus{entities.OrderStatusProcessed},
+	}); err != nil {
+		b.Fatal(err)
+	}
+	srv := httptest.NewServer(mock.Route())
+	defer srv.Close()
+	limiter := NewRateLimiter(0, 0)
+	breaker := NewCircuitBreaker(logger, 100, time.Minute, 1)
+	newClient := func(client *http.Client) *AccrualClient {
+		return NewAccrualClient(
+			logger, srv.URL, client, nil, 1, 1, time.Second, time.Millisecond, time.Millisecond, limiter, breaker,
+		)
+	}
+	b.Run("shared transport", func(b *testing.B) {
+		client := newClient(NewHTTPClient(100, 100, time.Minute, time.Second, time.Minute, time.Second, time.Second, false))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := client.GetAccrual(context.Background(), testOrder); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("per-call client", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := newClient(&http.Client{}).GetAccrual(context.Background(), testOrder); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	accrualv1 "github.com/matthiasBT/gophermart/api/accrual/v1"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// GRPCAccrualClient talks to accrual systems serving the Accrual service from api/accrual/v1
+type GRPCAccrualClient struct {
+	logger      logging.ILogger
+	conn        *grpc.ClientConn
+	client      accrualv1.AccrualClient
+	credentials map[string]string
+	maxAttempts int
+	timeout     time.Duration
+	retryBase   time.Duration
+	retryMax    time.Duration
+	limiter     entities.IRateLimiter
+	breaker     entities.ICircuitBreaker
+}
+
+func NewGRPCAccrualClient(
+	logger logging.ILogger,
+	address string,
+	insecureConn bool,
+	credentials map[string]string,
+	maxAttempts int,
+	timeout time.Duration,
+	retryBase time.Duration,
+	retryMax time.Duration,
+	limiter entities.IRateLimiter,
+	breaker entities.ICircuitBreaker,
+) (*GRPCAccrualClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(transportCredentials(insecureConn)))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCAccrualClient{
+		logger:      logger,
+		conn:        conn,
+		client:      accrualv1.NewAccrualClient(conn),
+		credentials: credentials,
+		maxAttempts: maxAttempts,
+		timeout:     timeout,
+		retryBase:   retryBase,
+		retryMax:    retryMax,
+		limiter:     limiter,
+		breaker:     breaker,
+	}, nil
+}
+
+func transportCredentials(insecureConn bool) credentials.TransportCredentials {
+	if insecureConn {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+}
+
+func (gc *GRPCAccrualClient) GetAccrual(ctx context.Context, orderNumber string) (*entities.AccrualResponse, error) {
+	return callThroughBreaker(ctx, gc.breaker, gc.logger, orderNumber, gc.getAccrual)
+}
+
+func (gc *GRPCAccrualClient) Close() error {
+	return gc.conn.Close()
+}
+
+func (gc *GRPCAccrualClient) getAccrual(ctx context.Context, orderNumber string) (*entities.AccrualResponse, error) {
+	gc.logger.Infof("Sending gRPC request for order accrual: %s", orderNumber)
+	for header, value := range gc.credentials {
+		ctx = metadata.AppendToOutgoingContext(ctx, header, value)
+	}
+	var lastErr error
+	for i := 1; i <= gc.maxAttempts; i++ {
+		if err := gc.limiter.Wait(ctx); err != nil {
+			return nil, errors.New("request aborted")
+		}
+		accrual, delay, err := gc.attempt(ctx, orderNumber, i)
+		if !errors.Is(err, errRetryable) {
+			return accrual, err
+		}
+		lastErr = err
+		if i == gc.maxAttempts || delay == 0 {
+			continue
+		}
+		gc.logger.Warningf("Retrying after %v", delay)
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("request aborted")
+		case <-time.After(delay):
+		}
+	}
+	gc.logger.Errorf("Failed to get data from the gRPC accrual system")
+	return nil, exhaustedError(lastErr)
+}
+
+func (gc *GRPCAccrualClient) attempt(
+	ctx context.Context, orderNumber string, attempt int,
+) (*entities.AccrualResponse, time.Duration, error) {
+	callCtx, cancel := context.WithTimeout(ctx, gc.timeout)
+	defer cancel()
+	callCtx, span := tracing.Tracer().Start(callCtx, accrualv1.Accrual_GetOrder_FullMethodName, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(attribute.Int("accrual.attempt", attempt))
+	md, _ := metadata.FromOutgoingContext(callCtx)
+	md = md.Copy()
+	otel.GetTextMapPropagator().Inject(callCtx, metadataCarrier(md))
+	callCtx = metadata.NewOutgoingContext(callCtx, md)
+	gc.logger.Infof("gRPC accrual system request for order %s. Attempt: %d", orderNumber, attempt)
+	start := time.Now()
+	order, err := gc.client.GetOrder(callCtx, &accrualv1.GetOrderRequest{Order: orderNumber})
+	recordRoundTrip(ctx, time.Since(start))
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+	if status.Code(err) != codes.OK && status.Code(err) != codes.NotFound {
+		tracing.RecordError(span, err)
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		// the raw response is stored as JSON, the same way HTTP responses are
+		body, err := protojson.Marshal(order)
+		if err != nil {
+			gc.logger.Errorf("Failed to encode response: %s", err.Error())
+			return nil, 0, fmt.Errorf("%w: %v", entities.ErrAccrualRejected, err)
+		}
+		accrual := &entities.AccrualResponse{
+			OrderNumber: order.GetOrder(),
+			Status:      entities.OrderStatus(order.GetStatus()),
+			Amount:      order.GetAccrual(),
+			Raw:         body,
+		}
+		gc.logger.Infof("Got accrual data for order %s: %s", accrual.OrderNumber, body)
+		return accrual, 0, nil
+	case codes.NotFound:
+		gc.logger.Infof("Order %s is unknown to the gRPC accrual system", orderNumber)
+		return nil, 0, nil
+	case codes.ResourceExhausted:
+		gc.logger.Infoln("Too many requests, need to wait for a while")
+		gc.limiter.Pause(gc.backoff(attempt))
+		return nil, 0, fmt.Errorf("%w: %w", errRetryable, entities.ErrAccrualThrottled)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		if ctx.Err() != nil {
+			return nil, 0, errors.New("request aborted")
+		}
+		gc.logger.Warningf("Transient failure of the gRPC accrual system: %v", err)
+		return nil, gc.backoff(attempt), fmt.Errorf("%w: %v", errRetryable, err)
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unimplemented:
+		gc.logger.Errorf("Non-retriable failure of the gRPC accrual system: %v", err)
+		return nil, 0, fmt.Errorf("%w: %v", entities.ErrAccrualUnavailable, err)
+	default:
+		gc.logger.Errorf("Non-retriable response from the gRPC accrual system: %v", err)
+		return nil, 0, fmt.Errorf("%w: %v", entities.ErrAccrualRejected, err)
+	}
+}
+
+func (gc *GRPCAccrualClient) backoff(attempt int) time.Duration {
+	return withJitter(exponentialBackoff(attempt, gc.retryBase, gc.retryMax))
+}
+
+// metadataCarrier lets the OpenTelemetry propagator write trace headers into gRPC metadata
+type metadataCarrier metadata.MD
+
+func (mc metadataCarrier) Get(key string) string {
+	if values := metadata.MD(mc).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (mc metadataCarrier) Set(key string, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for key := range mc {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,112 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	accrualv1 "github.com/matthiasBT/gophermart/api/accrual/v1"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// scriptedAccrualServer answers with the given codes in turn and repeats the last one after that
+type scriptedAccrualServer struct {
+	accrualv1.UnimplementedAccrualServer
+	codes []codes.Code
+	calls int32
+}
+
+func (s *scriptedAccrualServer) GetOrder(
+	ctx context.Context, req *accrualv1.GetOrderRequest,
+) (*accrualv1.Order, error) {
+	i := int(atomic.AddInt32(&s.calls, 1)) - 1
+	if i >= len(s.codes) {
+		i = len(s.codes) - 1
+	}
+	if s.codes[i] != codes.OK {
+		return nil, status.Error(s.codes[i], "scripted failure")
+	}
+	return &accrualv1.Order{Order: req.GetOrder(), Status: "PROCESSED", Accrual: proto.Float32(500)}, nil
+}
+
+func newTestGRPCAccrualClient(t *testing.T, server *scriptedAccrualServer, maxAttempts int) *GRPCAccrualClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	accrualv1.RegisterAccrualServer(srv, server)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	logger := testLogger(t)
+	client, err := NewGRPCAccrualClient(
+		logger, listener.Addr().String(), true, nil, maxAttempts, time.Second, time.Millisecond, 5*time.Millisecond,
+		&pauseRecorder{}, NewCircuitBreaker(logger, 100, time.Minute, 1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGRPCGetAccrual(t *testing.T) {
+	tests := []struct {
+		name      string
+		codes     []codes.Code
+		attempts  int
+		wantCalls int32
+		wantErr   error
+		wantResp  bool
+	}{
+		{"ok", []codes.Code{codes.OK}, 3, 1, nil, true},
+		{"not found", []codes.Code{codes.NotFound}, 3, 1, nil, false},
+		{"unavailable then ok", []codes.Code{codes.Unavailable, codes.OK}, 3, 2, nil, true},
+		{"retries stop at the bound", []codes.Code{codes.Unavailable}, 2, 2, entities.ErrAccrualUnavailable, false},
+		{"throttled until the bound", []codes.Code{codes.ResourceExhausted}, 2, 2, entities.ErrAccrualThrottled, false},
+		{"not retried on internal", []codes.Code{codes.Internal, codes.OK}, 3, 1, entities.ErrAccrualUnavailable, false},
+		{"not retried on invalid argument", []codes.Code{codes.InvalidArgument, codes.OK}, 3, 1, entities.ErrAccrualRejected, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &scriptedAccrualServer{codes: tt.codes}
+			client := newTestGRPCAccrualClient(t, server, tt.attempts)
+			resp, err := client.GetAccrual(context.Background(), testOrder)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetAccrual() error = %v, want %v", err, tt.wantErr)
+			}
+			if (resp != nil) != tt.wantResp {
+				t.Errorf("GetAccrual() = %+v, want response %v", resp, tt.wantResp)
+			}
+			if calls := atomic.LoadInt32(&server.calls); calls != tt.wantCalls {
+				t.Errorf("server got %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGRPCGetAccrualResponse(t *testing.T) {
+	client := newTestGRPCAccrualClient(t, &scriptedAccrualServer{codes: []codes.Code{codes.OK}}, 1)
+	resp, err := client.GetAccrual(context.Background(), testOrder)
+	if err != nil {
+		t.Fatalf("GetAccrual() error = %v", err)
+	}
+	if resp.OrderNumber != testOrder || resp.Status != entities.OrderStatusProcessed || resp.Amount != 500 {
+		t.Errorf("GetAccrual() = %+v, want a PROCESSED accrual of 500 for %s", resp, testOrder)
+	}
+	var raw entities.AccrualResponse
+	if err := json.Unmarshal(resp.Raw, &raw); err != nil {
+		t.Fatalf("raw response %s is not JSON: %v", resp.Raw, err)
+	}
+	if raw.OrderNumber != resp.OrderNumber || raw.Status != resp.Status || raw.Amount != resp.Amount {
+		t.Errorf("raw response %s differs from %+v", resp.Raw, resp)
+	}
+}
@@ -0,0 +1,154 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/metrics"
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type accrualProvider struct {
+	name     string
+	kind     string
+	provider entities.IAccrualProvider
+	limiter  entities.IRateLimiter
+	breaker  entities.ICircuitBreaker
+}
+
+// AccrualProviderRegistry routes new orders to accrual providers and sends accrual requests
+// to the provider an order was routed to
+type AccrualProviderRegistry struct {
+	logger      logging.ILogger
+	defaultName string
+	providers   map[string]*accrualProvider
+	names       []string
+	routes      []config.RouteConfig
+}
+
+func NewAccrualProviderRegistry(logger logging.ILogger, conf *config.ProvidersConfig) *AccrualProviderRegistry {
+	return &AccrualProviderRegistry{
+		logger:      logger,
+		defaultName: conf.Default,
+		providers:   make(map[string]*accrualProvider),
+		routes:      conf.Routes,
+	}
+}
+
+func (r *AccrualProviderRegistry) Register(
+	name string,
+	kind string,
+	provider entities.IAccrualProvider,
+	limiter entities.IRateLimiter,
+	breaker entities.ICircuitBreaker,
+) {
+	r.logger.Infof("Registering %s accrual provider %s", kind, name)
+	r.providers[name] = &accrualProvider{
+		name:     name,
+		kind:     kind,
+		provider: provider,
+		limiter:  limiter,
+		breaker:  breaker,
+	}
+	r.names = append(r.names, name)
+}
+
+func (r *AccrualProviderRegistry) Route(orderNumber string, partner string) string {
+	for _, route := range r.routes {
+		if route.Prefix != "" && !strings.HasPrefix(orderNumber, route.Prefix) {
+			continue
+		}
+		if route.Partner != "" && route.Partner != partner {
+			continue
+		}
+		return route.Provider
+	}
+	return r.defaultName
+}
+
+func (r *AccrualProviderRegistry) GetAccrual(
+	ctx context.Context, provider string, orderNumber string,
+) (*entities.AccrualResponse, error) {
+	p, err := r.resolve(provider)
+	if err != nil {
+		return nil, err
+	}
+	provider = p.name
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.GetAccrual", trace.WithAttributes(
+		attribute.String("accrual.provider", provider), attribute.String("order.number", orderNumber),
+	))
+	defer span.End()
+	start := time.Now()
+	resp, err := p.provider.GetAccrual(ctx, orderNumber)
+	tracing.RecordError(span, err)
+	metrics.AccrualDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	metrics.AccrualRequests.WithLabelValues(provider, accrualOutcome(resp, err)).Inc()
+	return resp, err
+}
+
+func accrualOutcome(resp *entities.AccrualResponse, err error) string {
+	switch {
+	case errors.Is(err, entities.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, entities.ErrAccrualThrottled):
+		return "throttled"
+	case errors.Is(err, entities.ErrAccrualRejected):
+		return "rejected"
+	case err != nil:
+		return "error"
+	case resp == nil:
+		return "no_content"
+	default:
+		return "ok"
+	}
+}
+
+func (r *AccrualProviderRegistry) States() []entities.ProviderState {
+	states := make([]entities.ProviderState, 0, len(r.names))
+	for _, name := range r.names {
+		p := r.providers[name]
+		states = append(states, entities.ProviderState{
+			Name:    p.name,
+			Kind:    p.kind,
+			Default: p.name == r.defaultName,
+			Limiter: p.limiter.State(),
+			Breaker: p.breaker.State(),
+		})
+	}
+	return states
+}
+
+func (r *AccrualProviderRegistry) Limiter(provider string) (entities.IRateLimiter, error) {
+	p, err := r.resolve(provider)
+	if err != nil {
+		return nil, err
+	}
+	return p.limiter, nil
+}
+
+func (r *AccrualProviderRegistry) Breaker(provider string) (entities.ICircuitBreaker, error) {
+	p, err := r.resolve(provider)
+	if err != nil {
+		return nil, err
+	}
+	return p.breaker, nil
+}
+
+func (r *AccrualProviderRegistry) resolve(provider string) (*accrualProvider, error) {
+	if provider == "" {
+		provider = r.defaultName
+	}
+	p, ok := r.providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", entities.ErrUnknownAccrualProvider, provider)
+	}
+	return p, nil
+}
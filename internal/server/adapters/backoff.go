@@ -0,0 +1,26 @@
+package adapters
+
+import (
+	"math/rand"
+	"time"
+)
+
+func exponentialBackoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// withJitter spreads retries of many orders over [delay/2, delay) so that they don't hit the accrual system at once
+func withJitter(delay time.Duration) time.Duration {
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
@@ -0,0 +1,177 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type CircuitBreaker struct {
+	logger           logging.ILogger
+	mu               sync.Mutex
+	state            entities.CircuitState
+	failures         int
+	successes        int
+	probes           int
+	probeTicket      entities.BreakerTicket
+	openedAt         time.Time
+	trips            int64
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenCalls    int
+	now              func() time.Time
+}
+
+func NewCircuitBreaker(
+	logger logging.ILogger, failureThreshold int, openTimeout time.Duration, halfOpenCalls int,
+) *CircuitBreaker {
+	return &CircuitBreaker{
+		logger:           logger,
+		state:            entities.CircuitClosed,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		halfOpenCalls:    halfOpenCalls,
+		now:              time.Now,
+	}
+}
+
+// callThroughBreaker runs the accrual request unless the breaker is open and reports its outcome.
+// Cancellation by the caller, throttling and rejected requests say nothing about the health
+// of the accrual system, so they are counted neither as successes nor as failures
+func callThroughBreaker(
+	ctx context.Context,
+	breaker entities.ICircuitBreaker,
+	logger logging.ILogger,
+	orderNumber string,
+	call func(ctx context.Context, orderNumber string) (*entities.AccrualResponse, error),
+) (*entities.AccrualResponse, error) {
+	ticket, err := breaker.Allow()
+	if err != nil {
+		logger.Warningf("Not requesting accrual for order %s: %v", orderNumber, err)
+		return nil, err
+	}
+	resp, err := call(ctx, orderNumber)
+	switch {
+	case ctx.Err() != nil:
+		breaker.Release(ticket)
+	case err == nil:
+		breaker.Success(ticket)
+	case errors.Is(err, entities.ErrAccrualUnavailable):
+		breaker.Failure(ticket)
+	default:
+		breaker.Release(ticket)
+	}
+	return resp, err
+}
+
+// Allow must be followed by Success, Failure or Release with the returned ticket once the call is done
+func (cb *CircuitBreaker) Allow() (entities.BreakerTicket, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.expire()
+	switch cb.state {
+	case entities.CircuitOpen:
+		return 0, entities.ErrCircuitOpen
+	case entities.CircuitHalfOpen:
+		if cb.probes >= cb.halfOpenCalls {
+			return 0, entities.ErrCircuitOpen
+		}
+		cb.probes++
+		return cb.probeTicket, nil
+	}
+	return 0, nil
+}
+
+// Outcomes of calls admitted before the breaker went half-open say nothing about the recovery and are ignored
+// while it's half-open
+func (cb *CircuitBreaker) Success(ticket entities.BreakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case entities.CircuitHalfOpen:
+		if !cb.isProbe(ticket) {
+			return
+		}
+		cb.probes--
+		cb.successes++
+		if cb.successes >= cb.halfOpenCalls {
+			cb.logger.Infoln("Accrual circuit breaker is closed")
+			cb.state = entities.CircuitClosed
+			cb.failures = 0
+		}
+	case entities.CircuitClosed:
+		cb.failures = 0
+	}
+}
+
+func (cb *CircuitBreaker) Failure(ticket entities.BreakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case entities.CircuitHalfOpen:
+		if !cb.isProbe(ticket) {
+			return
+		}
+		cb.probes--
+		cb.trip()
+	case entities.CircuitClosed:
+		cb.failures++
+		if cb.failures >= cb.failureThreshold {
+			cb.trip()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) Release(ticket entities.BreakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.isProbe(ticket) {
+		cb.probes--
+	}
+}
+
+// isProbe tells whether the ticket was handed out in the current half-open period. Must be called under the lock
+func (cb *CircuitBreaker) isProbe(ticket entities.BreakerTicket) bool {
+	return cb.state == entities.CircuitHalfOpen && ticket != 0 && ticket == cb.probeTicket
+}
+
+// State reports the breaker as half-open once the open timeout has passed, so that callers checking it
+// before sending requests let the probes through
+func (cb *CircuitBreaker) State() entities.BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.expire()
+	state := entities.BreakerState{
+		State:    cb.state,
+		Failures: cb.failures,
+		Trips:    cb.trips,
+	}
+	if cb.state != entities.CircuitClosed {
+		openedAt := cb.openedAt
+		state.OpenedAt = &openedAt
+	}
+	return state
+}
+
+// expire moves an open breaker to half-open after the open timeout. Must be called under the lock
+func (cb *CircuitBreaker) expire() {
+	if cb.state != entities.CircuitOpen || cb.now().Sub(cb.openedAt) < cb.openTimeout {
+		return
+	}
+	cb.logger.Infoln("Accrual circuit breaker is half-open, probing the accrual system")
+	cb.state = entities.CircuitHalfOpen
+	cb.successes = 0
+	cb.probes = 0
+	cb.probeTicket++
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.logger.Warningf("Accrual circuit breaker is open for %v", cb.openTimeout)
+	cb.state = entities.CircuitOpen
+	cb.openedAt = cb.now()
+	cb.trips++
+}
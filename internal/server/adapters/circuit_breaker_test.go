@@ -0,0 +1,159 @@
+package adapters
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const testOpenTimeout = 30 * time.Second
+
+func newTestCircuitBreaker(t *testing.T, clock *testClock, failureThreshold int, halfOpenCalls int) *CircuitBreaker {
+	breaker := NewCircuitBreaker(testLogger(t), failureThreshold, testOpenTimeout, halfOpenCalls)
+	breaker.now = clock.Now
+	return breaker
+}
+
+// breakerStep runs an operation on the breaker. Success, failure and release finish the oldest call
+// admitted by allow
+type breakerStep struct {
+	op      string // allow, success, failure, release or wait
+	advance time.Duration
+	wantErr error
+	want    entities.CircuitState
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     int
+		halfOpenCalls int
+		steps         []breakerStep
+	}{
+		{"stays closed below the threshold", 3, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "success", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitClosed},
+		}},
+		{"opens at the threshold", 2, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "allow", wantErr: entities.ErrCircuitOpen, want: entities.CircuitOpen},
+		}},
+		{"half-open after the timeout without calls", 1, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout - time.Second, want: entities.CircuitOpen},
+			{op: "wait", advance: time.Second, want: entities.CircuitHalfOpen},
+		}},
+		{"closes after successful probes", 1, 2, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "allow", wantErr: entities.ErrCircuitOpen, want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+		}},
+		{"reopens after a failed probe", 1, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "allow", wantErr: entities.ErrCircuitOpen, want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+		}},
+		{"released probe frees its slot", 1, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "release", want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitClosed},
+		}},
+		{"calls admitted while closed don't count as probes", 1, 1, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitHalfOpen},
+			{op: "allow", wantErr: entities.ErrCircuitOpen, want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitClosed},
+		}},
+		{"probes of an earlier half-open period don't count", 1, 2, []breakerStep{
+			{op: "allow", want: entities.CircuitClosed},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "failure", want: entities.CircuitOpen},
+			{op: "wait", advance: testOpenTimeout, want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitHalfOpen},
+			{op: "allow", want: entities.CircuitHalfOpen},
+			{op: "allow", wantErr: entities.ErrCircuitOpen, want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitHalfOpen},
+			{op: "success", want: entities.CircuitClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			breaker := newTestCircuitBreaker(t, clock, tt.threshold, tt.halfOpenCalls)
+			var admitted []entities.BreakerTicket
+			finish := func() entities.BreakerTicket {
+				ticket := admitted[0]
+				admitted = admitted[1:]
+				return ticket
+			}
+			for i, step := range tt.steps {
+				switch step.op {
+				case "allow":
+					ticket, err := breaker.Allow()
+					if !errors.Is(err, step.wantErr) {
+						t.Fatalf("step %d: Allow() error = %v, want %v", i, err, step.wantErr)
+					}
+					if err == nil {
+						admitted = append(admitted, ticket)
+					}
+				case "success":
+					breaker.Success(finish())
+				case "failure":
+					breaker.Failure(finish())
+				case "release":
+					breaker.Release(finish())
+				case "wait":
+					clock.Advance(step.advance)
+				}
+				if state := breaker.State().State; state != step.want {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, step.op, state, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	clock := newTestClock()
+	breaker := newTestCircuitBreaker(t, clock, 1, 1)
+	ticket, _ := breaker.Allow()
+	breaker.Failure(ticket)
+	state := breaker.State()
+	if state.Trips != 1 || state.OpenedAt == nil || !state.OpenedAt.Equal(clock.Now()) {
+		t.Errorf("State() = %+v, want one trip opened at %v", state, clock.Now())
+	}
+}
@@ -0,0 +1,244 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const latencySmoothing = 0.2
+
+type CollectorFactory func(name string, client entities.IAccrualClient, jobs <-chan entities.Job) *Collector
+
+// CollectorPool keeps between min and max Collector workers running, growing when the job queue backs up
+// and shrinking when the queue is empty, the accrual systems slow down or their rate limits are exhausted
+type CollectorPool struct {
+	logger      logging.ILogger
+	supervisor  *Supervisor
+	providers   entities.IAccrualProviderRegistry
+	client      entities.IAccrualClient
+	newWorker   CollectorFactory
+	jobs        chan entities.Job
+	tracker     *JobTracker
+	tick        <-chan time.Time
+	maxLatency  time.Duration
+	lock        sync.Mutex
+	min         int
+	max         int
+	workers     []context.CancelFunc
+	nextID      int
+	avgLatency  time.Duration
+	hasObserved bool
+}
+
+func NewCollectorPool(
+	logger logging.ILogger,
+	supervisor *Supervisor,
+	providers entities.IAccrualProviderRegistry,
+	client entities.IAccrualClient,
+	newWorker CollectorFactory,
+	jobs chan entities.Job,
+	tracker *JobTracker,
+	tick <-chan time.Time,
+	min int,
+	max int,
+	maxLatency time.Duration,
+) (*CollectorPool, error) {
+	if min < 1 || min > max {
+		return nil, entities.ErrInvalidPoolSize
+	}
+	return &CollectorPool{
+		logger:     logger,
+		supervisor: supervisor,
+		providers:  providers,
+		client:     client,
+		newWorker:  newWorker,
+		jobs:       jobs,
+		tracker:    tracker,
+		tick:       tick,
+		maxLatency: maxLatency,
+		min:        min,
+		max:        max,
+	}, nil
+}
+
+func (p *CollectorPool) Run(ctx context.Context) {
+	p.logger.Infoln("Launching the collector pool")
+	p.lock.Lock()
+	p.scaleTo(p.clamp(len(p.workers)))
+	p.lock.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			p.logger.Infoln("Stopping the collector pool")
+			return
+		case <-p.tick:
+			p.autoscale()
+		}
+	}
+}
+
+func (p *CollectorPool) State() entities.PoolState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pending := p.tracker.Len()
+	// tracked jobs that have left the queue are being processed by a collector
+	busy := max(0, min(pending-len(p.jobs), len(p.workers)))
+	return entities.PoolState{
+		Size:          len(p.workers),
+		Min:           p.min,
+		Max:           p.max,
+		QueueDepth:    len(p.jobs),
+		QueueCapacity: cap(p.jobs),
+		Pending:       pending,
+		Busy:          busy,
+		AvgLatencyMs:  float64(p.avgLatency) / float64(time.Millisecond),
+	}
+}
+
+func (p *CollectorPool) Resize(min, max int) error {
+	if min < 1 || min > max {
+		return entities.ErrInvalidPoolSize
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.logger.Infof("Resizing the collector pool to [%d, %d]", min, max)
+	p.min, p.max = min, max
+	p.scaleTo(p.clamp(len(p.workers)))
+	return nil
+}
+
+func (p *CollectorPool) autoscale() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	size := len(p.workers)
+	if target := p.target(size); target != size {
+		p.logger.Infof(
+			"Scaling the collector pool from %d to %d workers. Queue depth: %d, latency: %v",
+			size, target, len(p.jobs), p.avgLatency,
+		)
+		p.scaleTo(target)
+	}
+}
+
+func (p *CollectorPool) target(size int) int {
+	paused, perMinute := p.accrualBudget()
+	depth := len(p.jobs)
+	target := size
+	switch {
+	case paused:
+		target = size - 1
+	case p.maxLatency > 0 && p.avgLatency > p.maxLatency:
+		target = size - 1
+	case depth > size:
+		target = size * 2
+	case depth == 0:
+		target = size - 1
+	}
+	// with a rate limit, workers beyond rate * latency would only wait for tokens
+	if perMinute > 0 && p.avgLatency > 0 {
+		useful := int(math.Ceil(float64(perMinute)/60*p.avgLatency.Seconds())) + 1
+		if target > useful {
+			target = useful
+		}
+	}
+	return p.clamp(target)
+}
+
+// accrualBudget sums up the rate limits of all providers. The pool is paused only when every provider is,
+// and zero stands for no limit when any of them is unlimited
+func (p *CollectorPool) accrualBudget() (paused bool, perMinute int) {
+	states := p.providers.States()
+	paused = len(states) > 0
+	unlimited := false
+	for _, state := range states {
+		paused = paused && state.Limiter.PausedUntil != nil
+		unlimited = unlimited || state.Limiter.RequestsPerMinute <= 0
+		perMinute += state.Limiter.RequestsPerMinute
+	}
+	if unlimited {
+		return paused, 0
+	}
+	return paused, perMinute
+}
+
+func (p *CollectorPool) clamp(size int) int {
+	if size < p.min {
+		return p.min
+	}
+	if size > p.max {
+		return p.max
+	}
+	return size
+}
+
+func (p *CollectorPool) scaleTo(size int) {
+	for len(p.workers) < size {
+		name := fmt.Sprintf("worker-%d", p.nextID)
+		p.nextID++
+		worker := p.newWorker(name, &timedAccrualClient{client: p.client, observe: p.observe}, p.jobs)
+		p.workers = append(p.workers, p.supervisor.Go(name, worker.Run))
+	}
+	for len(p.workers) > size {
+		last := len(p.workers) - 1
+		p.workers[last]()
+		p.workers = p.workers[:last]
+	}
+}
+
+func (p *CollectorPool) observe(latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.hasObserved {
+		p.avgLatency = latency
+		p.hasObserved = true
+		return
+	}
+	p.avgLatency = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(p.avgLatency))
+}
+
+// timedAccrualClient observes the average round-trip of the requests sent for a lookup. Rate limiter waits,
+// throttling pauses and retry delays aren't counted, neither are cache hits that send no request at all
+type timedAccrualClient struct {
+	client  entities.IAccrualClient
+	observe func(latency time.Duration)
+}
+
+func (c *timedAccrualClient) GetAccrual(
+	ctx context.Context, provider string, orderNumber string,
+) (*entities.AccrualResponse, error) {
+	ctx, trips := withRoundTrips(ctx)
+	resp, err := c.client.GetAccrual(ctx, provider, orderNumber)
+	if count := trips.count.Load(); count > 0 {
+		c.observe(time.Duration(trips.total.Load() / count))
+	}
+	return resp, err
+}
+
+type roundTripsKey struct{}
+
+// roundTrips sums up the requests the accrual drivers send. A shared lookup may still record them
+// after the caller has returned, hence the atomics
+type roundTrips struct {
+	count atomic.Int64
+	total atomic.Int64
+}
+
+func withRoundTrips(ctx context.Context) (context.Context, *roundTrips) {
+	trips := new(roundTrips)
+	return context.WithValue(ctx, roundTripsKey{}, trips), trips
+}
+
+// recordRoundTrip is called by the accrual drivers for every request sent to an accrual system
+func recordRoundTrip(ctx context.Context, duration time.Duration) {
+	if trips, ok := ctx.Value(roundTripsKey{}).(*roundTrips); ok {
+		trips.count.Add(1)
+		trips.total.Add(int64(duration))
+	}
+}
@@ -0,0 +1,91 @@
+package adapters
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type subscription struct {
+	userID int
+	events chan entities.Event
+}
+
+type EventBus struct {
+	logger        logging.ILogger
+	mu            sync.Mutex
+	bufferSize    int
+	subscriptions map[*subscription]struct{}
+	closed        bool
+}
+
+func NewEventBus(logger logging.ILogger, bufferSize int) *EventBus {
+	return &EventBus{
+		logger:        logger,
+		bufferSize:    bufferSize,
+		subscriptions: make(map[*subscription]struct{}),
+	}
+}
+
+func (b *EventBus) Publish(event *entities.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscriptions {
+		if sub.userID != event.UserID {
+			continue
+		}
+		select {
+		case sub.events <- *event:
+		default:
+			// the subscriber is too slow: disconnect it, it will resume with Last-Event-ID
+			b.logger.Warningf("Dropping a slow event subscriber of user %d", sub.userID)
+			b.remove(sub)
+		}
+	}
+}
+
+// HandleNotification publishes an event relayed by another instance through PostgreSQL NOTIFY
+func (b *EventBus) HandleNotification(payload string) {
+	var event entities.Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		b.logger.Errorf("Failed to parse an event notification: %v", err)
+		return
+	}
+	b.Publish(&event)
+}
+
+func (b *EventBus) Subscribe(userID int) (<-chan entities.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &subscription{userID: userID, events: make(chan entities.Event, b.bufferSize)}
+	if b.closed {
+		close(sub.events)
+		return sub.events, func() {}
+	}
+	b.subscriptions[sub] = struct{}{}
+	b.logger.Infof("New event subscriber of user %d", userID)
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+	return sub.events, unsubscribe
+}
+
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscriptions {
+		b.remove(sub)
+	}
+}
+
+func (b *EventBus) remove(sub *subscription) {
+	if _, ok := b.subscriptions[sub]; ok {
+		delete(b.subscriptions, sub)
+		close(sub.events)
+	}
+}
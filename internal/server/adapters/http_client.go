@@ -0,0 +1,45 @@
+package adapters
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const maxDrainBytes = 64 << 10
+
+// NewHTTPClient builds a client with a pooled transport meant to be shared by all workers
+func NewHTTPClient(
+	maxIdleConns int,
+	maxIdleConnsPerHost int,
+	idleConnTimeout time.Duration,
+	dialTimeout time.Duration,
+	keepAlive time.Duration,
+	tlsHandshakeTimeout time.Duration,
+	responseHeaderTimeout time.Duration,
+	http2 bool,
+) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     http2,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// drainBody reads the rest of the body so the connection can go back to the pool
+func drainBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
@@ -0,0 +1,36 @@
+package adapters
+
+import "sync"
+
+// JobTracker remembers orders that are queued or being processed, so the Supplier doesn't enqueue them twice
+type JobTracker struct {
+	lock    sync.Mutex
+	pending map[string]struct{}
+}
+
+func NewJobTracker() *JobTracker {
+	return &JobTracker{pending: make(map[string]struct{})}
+}
+
+// Add reports false if the order is already queued or in flight
+func (t *JobTracker) Add(orderNumber string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.pending[orderNumber]; ok {
+		return false
+	}
+	t.pending[orderNumber] = struct{}{}
+	return true
+}
+
+func (t *JobTracker) Done(orderNumber string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.pending, orderNumber)
+}
+
+func (t *JobTracker) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.pending)
+}
@@ -0,0 +1,194 @@
+package adapters
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type LuhnValidator struct{}
+
+func (v LuhnValidator) Name() string {
+	return "luhn"
+}
+
+func (v LuhnValidator) Validate(number string) error {
+	if err := goluhn.Validate(number); err != nil {
+		return errors.New("failed the Luhn algorithm check")
+	}
+	return nil
+}
+
+// Mod97Validator implements ISO 7064 MOD 97-10: letters count as 10..35 and the whole value mod 97 must be 1
+type Mod97Validator struct{}
+
+func (v Mod97Validator) Name() string {
+	return "mod97"
+}
+
+func (v Mod97Validator) Validate(number string) error {
+	if len(number) < 3 {
+		return errors.New("too short for the ISO 7064 MOD 97-10 check")
+	}
+	remainder := 0
+	for _, ch := range strings.ToUpper(number) {
+		switch {
+		case ch >= '0' && ch <= '9':
+			remainder = (remainder*10 + int(ch-'0')) % 97
+		case ch >= 'A' && ch <= 'Z':
+			remainder = (remainder*100 + int(ch-'A') + 10) % 97
+		default:
+			return fmt.Errorf("unexpected character %q for the ISO 7064 MOD 97-10 check", ch)
+		}
+	}
+	if remainder != 1 {
+		return errors.New("failed the ISO 7064 MOD 97-10 check")
+	}
+	return nil
+}
+
+type EAN13Validator struct{}
+
+func (v EAN13Validator) Name() string {
+	return "ean13"
+}
+
+func (v EAN13Validator) Validate(number string) error {
+	if len(number) != 13 {
+		return errors.New("an EAN-13 number must have 13 digits")
+	}
+	sum := 0
+	for i, ch := range number {
+		if ch < '0' || ch > '9' {
+			return errors.New("an EAN-13 number must have 13 digits")
+		}
+		if i == 12 {
+			break
+		}
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(ch-'0') * weight
+	}
+	if (10-sum%10)%10 != int(number[12]-'0') {
+		return errors.New("failed the EAN-13 check digit")
+	}
+	return nil
+}
+
+const regexValidatorName = "regex"
+
+// RegexValidator checks the number against a prefix and a pattern. Partner validators are named
+// regex:<partner>, so that the recorded validator tells which pattern accepted the order
+type RegexValidator struct {
+	name    string
+	prefix  string
+	pattern *regexp.Regexp
+}
+
+func NewRegexValidator(partner string, prefix string, pattern string) (*RegexValidator, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	name := regexValidatorName
+	if partner != "" {
+		name += ":" + partner
+	}
+	return &RegexValidator{name: name, prefix: prefix, pattern: compiled}, nil
+}
+
+func (v *RegexValidator) Name() string {
+	return v.name
+}
+
+func (v *RegexValidator) Validate(number string) error {
+	if !strings.HasPrefix(number, v.prefix) {
+		return fmt.Errorf("order number must start with %q", v.prefix)
+	}
+	if !v.pattern.MatchString(strings.TrimPrefix(number, v.prefix)) {
+		return fmt.Errorf("order number doesn't match the pattern %s", v.pattern.String())
+	}
+	return nil
+}
+
+type OrderValidatorRegistry struct {
+	logger     logging.ILogger
+	validators map[string]entities.IOrderValidator
+	partners   map[string]entities.IOrderValidator
+	fallback   entities.IOrderValidator
+}
+
+// NewOrderValidatorRegistry sets up validators for partners users are assigned to. A partner with its own
+// prefix or pattern gets a dedicated regex validator, missing parts are taken from the global ones
+func NewOrderValidatorRegistry(
+	logger logging.ILogger,
+	defaultName string,
+	partners map[string]string,
+	regexPrefix string,
+	regexPattern string,
+	partnerPrefixes map[string]string,
+	partnerPatterns map[string]string,
+) (*OrderValidatorRegistry, error) {
+	regex, err := NewRegexValidator("", regexPrefix, regexPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order number pattern: %w", err)
+	}
+	registry := &OrderValidatorRegistry{
+		logger:     logger,
+		validators: make(map[string]entities.IOrderValidator),
+		partners:   make(map[string]entities.IOrderValidator),
+	}
+	for _, validator := range []entities.IOrderValidator{LuhnValidator{}, Mod97Validator{}, EAN13Validator{}, regex} {
+		registry.validators[validator.Name()] = validator
+	}
+	if registry.fallback, err = registry.lookup(defaultName); err != nil {
+		return nil, err
+	}
+	for partner, name := range partners {
+		if registry.partners[partner], err = registry.lookup(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, custom := range []map[string]string{partnerPrefixes, partnerPatterns} {
+		for partner := range custom {
+			if name, ok := partners[partner]; ok && name != regexValidatorName {
+				return nil, fmt.Errorf("partner %s has an order number prefix or pattern but uses %s", partner, name)
+			}
+			prefix, ok := partnerPrefixes[partner]
+			if !ok {
+				prefix = regexPrefix
+			}
+			pattern, ok := partnerPatterns[partner]
+			if !ok {
+				pattern = regexPattern
+			}
+			if registry.partners[partner], err = NewRegexValidator(partner, prefix, pattern); err != nil {
+				return nil, fmt.Errorf("invalid order number pattern of partner %s: %w", partner, err)
+			}
+		}
+	}
+	return registry, nil
+}
+
+func (r *OrderValidatorRegistry) Resolve(partner string) entities.IOrderValidator {
+	if validator, ok := r.partners[partner]; ok && partner != "" {
+		r.logger.Infof("Using the %s order number validator for partner %s", validator.Name(), partner)
+		return validator
+	}
+	return r.fallback
+}
+
+func (r *OrderValidatorRegistry) lookup(name string) (entities.IOrderValidator, error) {
+	validator, ok := r.validators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", entities.ErrUnknownOrderValidator, name)
+	}
+	return validator, nil
+}
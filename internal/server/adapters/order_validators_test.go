@@ -0,0 +1,84 @@
+package adapters
+
+import (
+	"testing"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+func TestOrderValidators(t *testing.T) {
+	regex, err := NewRegexValidator("", "", "^[0-9]+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixed, err := NewRegexValidator("acme", "AC-", "^[0-9]{4}$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		validator entities.IOrderValidator
+		number    string
+		valid     bool
+	}{
+		{"luhn valid", LuhnValidator{}, "79927398713", true},
+		{"luhn valid order", LuhnValidator{}, testOrder, true},
+		{"luhn wrong check digit", LuhnValidator{}, "79927398710", false},
+		{"luhn letters", LuhnValidator{}, "7992739871A", false},
+		{"mod97 valid", Mod97Validator{}, "WEST12345698765432GB82", true},
+		{"mod97 valid lowercase", Mod97Validator{}, "west12345698765432gb82", true},
+		{"mod97 valid digits", Mod97Validator{}, "3214282912345698765432161182", true},
+		{"mod97 wrong check digits", Mod97Validator{}, "WEST12345698765432GB83", false},
+		{"mod97 too short", Mod97Validator{}, "01", false},
+		{"mod97 punctuation", Mod97Validator{}, "WEST-12345698765432GB82", false},
+		{"ean13 valid", EAN13Validator{}, "4006381333931", true},
+		{"ean13 valid zero check digit", EAN13Validator{}, "9780306406157", true},
+		{"ean13 wrong check digit", EAN13Validator{}, "4006381333932", false},
+		{"ean13 too short", EAN13Validator{}, "400638133393", false},
+		{"ean13 too long", EAN13Validator{}, "40063813339310", false},
+		{"ean13 letters", EAN13Validator{}, "400638133393A", false},
+		{"regex valid", regex, "12345", true},
+		{"regex letters", regex, "12a45", false},
+		{"regex with prefix valid", prefixed, "AC-1234", true},
+		{"regex without prefix", prefixed, "1234", false},
+		{"regex with prefix too long", prefixed, "AC-12345", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validator.Validate(tt.number)
+			if (err == nil) != tt.valid {
+				t.Errorf("%s.Validate(%q) error = %v, want valid %v", tt.validator.Name(), tt.number, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestOrderValidatorRegistryNames(t *testing.T) {
+	registry, err := NewOrderValidatorRegistry(
+		testLogger(t),
+		"luhn",
+		map[string]string{"globex": "ean13"},
+		"",
+		"^[0-9]+$",
+		map[string]string{"acme": "AC-"},
+		map[string]string{"initech": "^X+$"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		partner string
+		want    string
+	}{
+		{"", "luhn"},
+		{"unknown", "luhn"},
+		{"globex", "ean13"},
+		{"acme", "regex:acme"},
+		{"initech", "regex:initech"},
+	}
+	for _, tt := range tests {
+		if got := registry.Resolve(tt.partner).Name(); got != tt.want {
+			t.Errorf("Resolve(%q).Name() = %q, want %q", tt.partner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package adapters
+
+import (
+	"context"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+// OutboxRelay publishes committed outbox events with NOTIFY. Only one instance relays at a time
+// (guarded by an advisory lock), so events are notified exactly once and in commit order.
+type OutboxRelay struct {
+	storage   entities.Storage
+	eventRepo entities.EventRepo
+	logger    logging.ILogger
+	channel   string
+	tick      <-chan time.Time
+	batchSize int
+}
+
+func NewOutboxRelay(
+	storage entities.Storage,
+	eventRepo entities.EventRepo,
+	logger logging.ILogger,
+	channel string,
+	tick <-chan time.Time,
+	batchSize int,
+) *OutboxRelay {
+	return &OutboxRelay{
+		storage:   storage,
+		eventRepo: eventRepo,
+		logger:    logger,
+		channel:   channel,
+		tick:      tick,
+		batchSize: batchSize,
+	}
+}
+
+func (r *OutboxRelay) Run(ctx context.Context) {
+	r.logger.Infoln("Launching the outbox relay")
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Infoln("Stopping the outbox relay")
+			return
+		case <-r.tick:
+			if err := r.relay(drainContext(ctx)); err != nil {
+				r.logger.Errorf("Outbox relay failed: %v", err)
+			}
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) error {
+	tx, err := r.storage.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	locked, err := r.eventRepo.LockRelay(ctx, tx)
+	if err != nil || !locked {
+		tx.Rollback()
+		return err
+	}
+	events, err := r.eventRepo.FetchUnpublishedEvents(ctx, tx, r.batchSize)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(events) == 0 {
+		return tx.Rollback()
+	}
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		if err := r.eventRepo.NotifyEvent(ctx, tx, r.channel, &event); err != nil {
+			tx.Rollback()
+			return err
+		}
+		ids = append(ids, event.ID)
+	}
+	if err := r.eventRepo.MarkEventsPublished(ctx, tx, ids); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.logger.Infof("Relayed %d events", len(events))
+	return nil
+}
@@ -0,0 +1,67 @@
+package adapters
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+)
+
+type PGListener struct {
+	logger     logging.ILogger
+	dsn        string
+	handlers   map[string]func(payload string)
+	retryDelay time.Duration
+}
+
+func NewPGListener(logger logging.ILogger, dsn string, retryDelay time.Duration) *PGListener {
+	return &PGListener{
+		logger:     logger,
+		dsn:        dsn,
+		handlers:   make(map[string]func(payload string)),
+		retryDelay: retryDelay,
+	}
+}
+
+func (l *PGListener) Handle(channel string, handler func(payload string)) {
+	l.handlers[channel] = handler
+}
+
+func (l *PGListener) Run(ctx context.Context) {
+	l.logger.Infoln("Launching the database notification listener")
+	for {
+		if err := l.listen(ctx); err != nil && ctx.Err() == nil {
+			l.logger.Errorf("Notification listener failed, reconnecting: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			l.logger.Infoln("Stopping the database notification listener")
+			return
+		case <-time.After(l.retryDelay):
+		}
+	}
+}
+
+func (l *PGListener) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, l.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	for channel := range l.handlers {
+		if _, err := conn.Exec(ctx, "listen "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+		l.logger.Infof("Listening to %s", channel)
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if handler, ok := l.handlers[notification.Channel]; ok {
+			handler(notification.Payload)
+		}
+	}
+}
@@ -31,6 +31,10 @@ func (pgtx *PGTx) GetContext(ctx context.Context, dest interface{}, query string
 	return pgtx.tx.GetContext(ctx, dest, query, args...)
 }
 
+func (pgtx *PGTx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return pgtx.tx.SelectContext(ctx, dest, query, args...)
+}
+
 func (pgtx *PGTx) ExecContext(ctx context.Context, query string, args ...any) error {
 	_, err := pgtx.tx.ExecContext(ctx, query, args...)
 	return err
@@ -53,6 +57,20 @@ func (st *PGStorage) Shutdown() {
 	}
 }
 
+// DB exposes the connection pool for stats collection
+func (st *PGStorage) DB() *sql.DB {
+	return st.db.DB
+}
+
+func (st *PGStorage) Ping(ctx context.Context) error {
+	return st.db.PingContext(ctx)
+}
+
+// CheckMigrations fails if migrations applied to the database lag behind the embedded ones
+func (st *PGStorage) CheckMigrations(ctx context.Context) error {
+	return migrations.Check(ctx, st.db.DB)
+}
+
 func (st *PGStorage) Tx(ctx context.Context) (entities.Tx, error) {
 	tx, err := st.db.BeginTxx(ctx, &txOpt)
 	if err != nil {
@@ -70,3 +88,8 @@ func (st *PGStorage) SelectContext(ctx context.Context, dest any, query string,
 func (st *PGStorage) GetContext(ctx context.Context, dest any, query string, args ...any) error {
 	return st.db.GetContext(ctx, dest, query, args...)
 }
+
+func (st *PGStorage) ExecContext(ctx context.Context, query string, args ...any) error {
+	_, err := st.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,191 @@
+package adapters
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+// After the accrual system lowers the rate, it's raised by rateRampFactor every rateRampInterval
+// without throttling until the configured rate is reached again
+const (
+	rateRampInterval = time.Minute
+	rateRampFactor   = 1.25
+)
+
+// RateLimiter is a token bucket shared by all Collector workers. A non-positive rate means no limit
+// until the accrual system tells us otherwise.
+type RateLimiter struct {
+	mu          sync.Mutex
+	perMinute   int
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	throttled   int64
+	// configured values are restored gradually after SetRate
+	configuredPerMinute int
+	configuredBurst     float64
+	adaptedPerMinute    int
+	rampFrom            time.Time
+	now                 func() time.Time
+}
+
+func NewRateLimiter(perMinute int, burst int) *RateLimiter {
+	return newRateLimiter(perMinute, burst, time.Now)
+}
+
+func newRateLimiter(perMinute int, burst int, now func() time.Time) *RateLimiter {
+	return &RateLimiter{
+		perMinute:           perMinute,
+		burst:               float64(burst),
+		tokens:              float64(burst),
+		last:                now(),
+		configuredPerMinute: perMinute,
+		configuredBurst:     float64(burst),
+		now:                 now,
+	}
+}
+
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (l *RateLimiter) Pause(duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled++
+	if until := l.now().Add(duration); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	if l.adaptedPerMinute > 0 {
+		l.rampFrom = l.pausedUntil
+	}
+}
+
+// SetRate lowers the rate to what the accrual system allows. The rate ramps back up to the configured one
+// if no throttling happens afterwards
+func (l *RateLimiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	l.adaptedPerMinute = perMinute
+	l.rampFrom = now
+	if l.pausedUntil.After(now) {
+		l.rampFrom = l.pausedUntil
+	}
+	l.setRate(perMinute)
+}
+
+func (l *RateLimiter) Configure(perMinute int, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	l.configuredPerMinute = perMinute
+	l.configuredBurst = float64(burst)
+	l.adaptedPerMinute = 0
+	l.perMinute = perMinute
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+func (l *RateLimiter) State() entities.RateLimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.ramp(now)
+	l.refill(now)
+	state := entities.RateLimiterState{
+		RequestsPerMinute:           l.perMinute,
+		ConfiguredRequestsPerMinute: l.configuredPerMinute,
+		Burst:                       int(l.burst),
+		Tokens:                      l.tokens,
+		Throttled:                   l.throttled,
+	}
+	if now.Before(l.pausedUntil) {
+		pausedUntil := l.pausedUntil
+		state.PausedUntil = &pausedUntil
+	}
+	return state
+}
+
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.ramp(now)
+	if l.perMinute <= 0 {
+		return 0
+	}
+	l.refill(now)
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.ratePerSecond() * float64(time.Second))
+}
+
+// ramp raises an adapted rate for every interval passed without throttling. Without a configured limit
+// the limiter becomes unlimited again once the rate has doubled
+func (l *RateLimiter) ramp(now time.Time) {
+	for l.adaptedPerMinute > 0 && now.Sub(l.rampFrom) >= rateRampInterval {
+		l.refill(l.rampFrom.Add(rateRampInterval))
+		l.rampFrom = l.rampFrom.Add(rateRampInterval)
+		next := int(math.Ceil(float64(l.perMinute) * rateRampFactor))
+		target := l.configuredPerMinute
+		if target <= 0 {
+			target = 2 * l.adaptedPerMinute
+		}
+		if next < target {
+			l.setRate(next)
+			continue
+		}
+		l.perMinute = l.configuredPerMinute
+		l.burst = l.configuredBurst
+		l.adaptedPerMinute = 0
+	}
+}
+
+func (l *RateLimiter) setRate(perMinute int) {
+	l.perMinute = perMinute
+	l.burst = l.configuredBurst
+	if perMinute > 0 && l.burst > float64(perMinute) {
+		l.burst = float64(perMinute)
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if l.perMinute > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.ratePerSecond()
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+func (l *RateLimiter) ratePerSecond() float64 {
+	return float64(l.perMinute) / 60
+}
@@ -0,0 +1,162 @@
+package adapters
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a manually advanced clock
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2023, 10, 20, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestRateLimiterBucket(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		want    time.Duration
+	}
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		steps     []step
+	}{
+		{"unlimited", 0, 1, []step{{0, 0}, {0, 0}, {0, 0}, {0, 0}}},
+		{"burst then wait", 60, 3, []step{{0, 0}, {0, 0}, {0, 0}, {0, time.Second}}},
+		{"partial refill", 60, 1, []step{{0, 0}, {0, time.Second}, {500 * time.Millisecond, 500 * time.Millisecond}}},
+		{"full refill", 60, 1, []step{{0, 0}, {time.Second, 0}, {0, time.Second}}},
+		{"refill is capped by the burst", 60, 2, []step{{0, 0}, {0, 0}, {time.Hour, 0}, {0, 0}, {0, time.Second}}},
+		{"slow rate", 6, 1, []step{{0, 0}, {0, 10 * time.Second}, {5 * time.Second, 5 * time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			limiter := newRateLimiter(tt.perMinute, tt.burst, clock.Now)
+			for i, s := range tt.steps {
+				clock.Advance(s.advance)
+				if got := limiter.reserve(); got != s.want {
+					t.Errorf("step %d: reserve() = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	tests := []struct {
+		name    string
+		pauses  []time.Duration
+		advance time.Duration
+		want    time.Duration
+	}{
+		{"paused", []time.Duration{10 * time.Second}, 0, 10 * time.Second},
+		{"partly expired", []time.Duration{10 * time.Second}, 4 * time.Second, 6 * time.Second},
+		{"expired", []time.Duration{10 * time.Second}, 10 * time.Second, 0},
+		{"the longest pause wins", []time.Duration{10 * time.Second, 2 * time.Second}, 0, 10 * time.Second},
+		{"a longer pause extends", []time.Duration{2 * time.Second, 10 * time.Second}, 0, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			limiter := newRateLimiter(0, 1, clock.Now)
+			for _, pause := range tt.pauses {
+				limiter.Pause(pause)
+			}
+			clock.Advance(tt.advance)
+			if got := limiter.reserve(); got != tt.want {
+				t.Errorf("reserve() = %v, want %v", got, tt.want)
+			}
+			state := limiter.State()
+			if state.Throttled != int64(len(tt.pauses)) {
+				t.Errorf("State().Throttled = %d, want %d", state.Throttled, len(tt.pauses))
+			}
+			if (state.PausedUntil != nil) != (tt.want > 0) {
+				t.Errorf("State().PausedUntil = %v, want paused %v", state.PausedUntil, tt.want > 0)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRamp(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int
+		adapted    int
+		want       []int // rate right after SetRate and after every ramp interval
+	}{
+		{"back to the configured rate", 100, 40, []int{40, 50, 63, 79, 99, 100, 100}},
+		{"back to unlimited once doubled", 0, 40, []int{40, 50, 63, 79, 0, 0}},
+		{"single step", 100, 90, []int{90, 100, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			limiter := newRateLimiter(tt.configured, 10, clock.Now)
+			limiter.SetRate(tt.adapted)
+			for i, want := range tt.want {
+				if i > 0 {
+					clock.Advance(rateRampInterval)
+				}
+				state := limiter.State()
+				if state.RequestsPerMinute != want {
+					t.Errorf("after %d intervals: rate = %d, want %d", i, state.RequestsPerMinute, want)
+				}
+				if state.ConfiguredRequestsPerMinute != tt.configured {
+					t.Errorf("configured rate = %d, want %d", state.ConfiguredRequestsPerMinute, tt.configured)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRampBurst(t *testing.T) {
+	clock := newTestClock()
+	limiter := newRateLimiter(100, 10, clock.Now)
+	limiter.SetRate(5)
+	if burst := limiter.State().Burst; burst != 5 {
+		t.Errorf("burst at the lowered rate = %d, want 5", burst)
+	}
+	clock.Advance(10 * rateRampInterval)
+	if burst := limiter.State().Burst; burst != 10 {
+		t.Errorf("burst after the ramp = %d, want the configured 10", burst)
+	}
+}
+
+func TestRateLimiterRampDelayedByThrottling(t *testing.T) {
+	clock := newTestClock()
+	limiter := newRateLimiter(100, 10, clock.Now)
+	limiter.SetRate(40)
+	clock.Advance(rateRampInterval)
+	if rate := limiter.State().RequestsPerMinute; rate != 50 {
+		t.Fatalf("rate after one interval = %d, want 50", rate)
+	}
+	limiter.Pause(30 * time.Second)
+	clock.Advance(rateRampInterval)
+	if rate := limiter.State().RequestsPerMinute; rate != 50 {
+		t.Errorf("rate one interval after throttling = %d, want 50 until an interval passes after the pause", rate)
+	}
+	clock.Advance(30 * time.Second)
+	if rate := limiter.State().RequestsPerMinute; rate != 63 {
+		t.Errorf("rate one interval after the pause = %d, want 63", rate)
+	}
+}
+
+func TestRateLimiterConfigure(t *testing.T) {
+	clock := newTestClock()
+	limiter := newRateLimiter(100, 10, clock.Now)
+	limiter.SetRate(40)
+	limiter.Configure(200, 5)
+	clock.Advance(10 * rateRampInterval)
+	state := limiter.State()
+	if state.RequestsPerMinute != 200 || state.Burst != 5 {
+		t.Errorf("State() = %d/min burst %d, want 200/min burst 5", state.RequestsPerMinute, state.Burst)
+	}
+}
@@ -0,0 +1,121 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+var errShuttingDown = errors.New("the server is shutting down")
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// ReadinessProbe runs the registered checks concurrently, each bounded by the timeout.
+// Once ShuttingDown is called the instance reports itself unready regardless of the checks.
+// Detail checks are only reported and never make the instance unready
+type ReadinessProbe struct {
+	timeout      time.Duration
+	checks       []readinessCheck
+	details      []readinessCheck
+	shuttingDown atomic.Bool
+}
+
+func NewReadinessProbe(timeout time.Duration) *ReadinessProbe {
+	return &ReadinessProbe{timeout: timeout}
+}
+
+func (p *ReadinessProbe) Register(name string, check func(ctx context.Context) error) {
+	p.checks = append(p.checks, readinessCheck{name: name, check: check})
+}
+
+func (p *ReadinessProbe) RegisterDetail(name string, check func(ctx context.Context) error) {
+	p.details = append(p.details, readinessCheck{name: name, check: check})
+}
+
+func (p *ReadinessProbe) ShuttingDown() {
+	p.shuttingDown.Store(true)
+}
+
+func (p *ReadinessProbe) Ready(ctx context.Context) *entities.ReadinessReport {
+	results := p.run(ctx, p.checks)
+	if p.shuttingDown.Load() {
+		results = append(results, entities.HealthCheck{
+			Name: "shutdown", Status: entities.CheckFailed, Error: errShuttingDown.Error(),
+		})
+	}
+	report := &entities.ReadinessReport{Status: entities.CheckPassed, Checks: results}
+	for _, result := range results {
+		if result.Status == entities.CheckFailed {
+			report.Status = entities.CheckFailed
+		}
+	}
+	return report
+}
+
+func (p *ReadinessProbe) Details(ctx context.Context) []entities.HealthCheck {
+	return p.run(ctx, p.details)
+}
+
+func (p *ReadinessProbe) run(ctx context.Context, checks []readinessCheck) []entities.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	results := make([]entities.HealthCheck, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			results[i] = entities.HealthCheck{Name: c.name, Status: entities.CheckPassed}
+			if err := c.check(ctx); err != nil {
+				results[i].Status = entities.CheckFailed
+				results[i].Error = err.Error()
+			}
+			results[i].DurationMs = time.Since(start).Milliseconds()
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// BreakerCheck fails while the circuit breaker of any accrual provider is open. An accrual outage only delays
+// order processing, so it's a detail check and doesn't take the instance out of rotation
+func BreakerCheck(providers entities.IAccrualProviderRegistry) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var open []string
+		for _, state := range providers.States() {
+			if state.Breaker.State == entities.CircuitOpen {
+				open = append(open, state.Name)
+			}
+		}
+		if open != nil {
+			return fmt.Errorf("circuit breaker is open for: %s", strings.Join(open, ", "))
+		}
+		return nil
+	}
+}
+
+// WorkersCheck fails if any supervised worker has stopped or is being restarted after a panic
+func WorkersCheck(supervisor entities.IWorkerSupervisor) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var down []string
+		for _, status := range supervisor.Statuses() {
+			if status.State != entities.WorkerRunning {
+				down = append(down, fmt.Sprintf("%s (%s)", status.Name, status.State))
+			}
+		}
+		if down != nil {
+			return fmt.Errorf("workers not running: %s", strings.Join(down, ", "))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,291 @@
+package adapters
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const amountTolerance = 0.005
+
+// Reconciler re-queries the accrual system for stored orders and reports where our data differs from it.
+// With adjustments enabled it brings the order status and accrual in line and records an audit entry.
+// Reconciliations run one at a time in the background, through accrual clients with their own rate limit
+type Reconciler struct {
+	client      entities.IAccrualClient
+	storage     entities.Storage
+	orderRepo   entities.OrderRepo
+	accrualRepo entities.AccrualRepo
+	logger      logging.ILogger
+	maxOrders   int
+	keepJobs    int
+	queue       chan *entities.ReconciliationJob
+	lock        sync.Mutex
+	jobs        []*entities.ReconciliationJob
+	lastID      int64
+}
+
+func NewReconciler(
+	client entities.IAccrualClient,
+	storage entities.Storage,
+	orderRepo entities.OrderRepo,
+	accrualRepo entities.AccrualRepo,
+	logger logging.ILogger,
+	maxOrders int,
+	keepJobs int,
+) *Reconciler {
+	return &Reconciler{
+		client:      client,
+		storage:     storage,
+		orderRepo:   orderRepo,
+		accrualRepo: accrualRepo,
+		logger:      logger.WithField("worker", "reconciler"),
+		maxOrders:   maxOrders,
+		keepJobs:    keepJobs,
+		queue:       make(chan *entities.ReconciliationJob, 1),
+	}
+}
+
+// Start queues a reconciliation unless another one is queued or running
+func (r *Reconciler) Start(req *entities.ReconciliationRequest) (*entities.ReconciliationJob, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, job := range r.jobs {
+		if !job.Done() {
+			return nil, entities.ErrReconciliationRunning
+		}
+	}
+	r.lastID++
+	job := &entities.ReconciliationJob{ID: r.lastID, State: entities.ReconciliationQueued, Request: *req}
+	r.jobs = append(r.jobs, job)
+	if len(r.jobs) > r.keepJobs {
+		r.jobs = r.jobs[len(r.jobs)-r.keepJobs:]
+	}
+	r.queue <- job
+	return snapshotJob(job), nil
+}
+
+func (r *Reconciler) Job(id int64) *entities.ReconciliationJob {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return snapshotJob(job)
+		}
+	}
+	return nil
+}
+
+// Jobs lists the recent reconciliations without their discrepancies
+func (r *Reconciler) Jobs() []entities.ReconciliationJob {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	jobs := make([]entities.ReconciliationJob, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		summary := snapshotJob(job)
+		if summary.Report != nil {
+			summary.Report.Discrepancies = nil
+		}
+		jobs = append(jobs, *summary)
+	}
+	return jobs
+}
+
+// snapshotJob copies the job so that it can be served while the reconciliation goes on. Must be called under the lock
+func snapshotJob(job *entities.ReconciliationJob) *entities.ReconciliationJob {
+	snapshot := *job
+	if job.Report != nil {
+		report := *job.Report
+		report.Discrepancies = append([]entities.Discrepancy{}, job.Report.Discrepancies...)
+		snapshot.Report = &report
+	}
+	return &snapshot
+}
+
+func (r *Reconciler) Run(ctx context.Context) {
+	r.logger.Infoln("Launching the Reconciler worker")
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Infoln("Stopping the Reconciler worker")
+			return
+		case job := <-r.queue:
+			if err := r.reconcile(ctx, job); err != nil {
+				r.logger.Errorf("Reconciliation %d failed: %v", job.ID, err)
+				r.update(func() {
+					job.State = entities.ReconciliationFailed
+					job.Error = err.Error()
+				})
+			}
+		}
+	}
+}
+
+func (r *Reconciler) update(change func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	change()
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, job *entities.ReconciliationJob) error {
+	req := &job.Request
+	report := &entities.ReconciliationReport{
+		From:          req.From,
+		To:            req.To,
+		StartedAt:     time.Now(),
+		Discrepancies: []entities.Discrepancy{},
+	}
+	r.update(func() {
+		job.State = entities.ReconciliationRunning
+		job.Report = report
+	})
+	// one more order than the cap tells whether the range was cut short
+	orders, err := r.orderRepo.FindOrdersForReconciliation(ctx, req.From, req.To, r.maxOrders+1)
+	if err != nil {
+		return err
+	}
+	r.update(func() {
+		if len(orders) > r.maxOrders {
+			report.Truncated = true
+			report.ResumeFrom = &orders[r.maxOrders].UploadedAt
+			orders = orders[:r.maxOrders]
+		}
+		report.Total = len(orders)
+	})
+	if report.Truncated {
+		r.logger.Warningf(
+			"Reconciliation %d is limited to %d orders, the rest starts at %v", job.ID, r.maxOrders, report.ResumeFrom,
+		)
+	}
+	r.logger.Infof("Reconciliation %d checks %d orders, adjusting: %v", job.ID, len(orders), req.Adjust)
+	for i := range orders {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		discrepancy := r.check(ctx, &orders[i], req.Adjust)
+		r.update(func() {
+			report.Checked++
+			if discrepancy == nil {
+				return
+			}
+			if discrepancy.Adjusted {
+				report.Adjusted++
+			}
+			report.Discrepancies = append(report.Discrepancies, *discrepancy)
+		})
+	}
+	r.update(func() {
+		finishedAt := time.Now()
+		report.FinishedAt = &finishedAt
+		job.State = entities.ReconciliationFinished
+	})
+	r.logger.Infof(
+		"Reconciliation %d finished: %d orders checked, %d discrepancies, %d adjusted",
+		job.ID,
+		report.Checked,
+		len(report.Discrepancies),
+		report.Adjusted,
+	)
+	return nil
+}
+
+// check compares the order with the accrual system. A final order without an accrual is reported
+// whatever the accrual system answers
+func (r *Reconciler) check(
+	ctx context.Context, order *entities.ReconciledOrder, adjust bool,
+) *entities.Discrepancy {
+	discrepancy := &entities.Discrepancy{
+		OrderNumber: order.Number,
+		UserID:      order.UserID,
+		LocalStatus: order.Status,
+		LocalAmount: order.Accrual,
+	}
+	missing := order.Status.IsFinal() && order.Accrual == nil
+	resp, err := r.client.GetAccrual(ctx, order.Provider, order.Number)
+	if err != nil {
+		discrepancy.Kind = entities.DiscrepancyRemoteError
+		if missing {
+			discrepancy.Kind = entities.DiscrepancyMissingAccrual
+		}
+		discrepancy.Error = err.Error()
+		return discrepancy
+	}
+	if resp == nil {
+		switch {
+		case missing:
+			discrepancy.Kind = entities.DiscrepancyMissingAccrual
+		case order.Status == entities.OrderStatusNew:
+			return nil
+		default:
+			discrepancy.Kind = entities.DiscrepancyUnknownRemote
+		}
+		return discrepancy
+	}
+	discrepancy.RemoteStatus = resp.Status
+	if resp.Status == entities.OrderStatusProcessed {
+		amount := resp.Amount
+		discrepancy.RemoteAmount = &amount
+	}
+	switch {
+	case resp.Status != order.Status:
+		discrepancy.Kind = entities.DiscrepancyStatusMismatch
+	case missing:
+		discrepancy.Kind = entities.DiscrepancyMissingAccrual
+	case resp.Status != entities.OrderStatusProcessed:
+		return nil
+	case math.Abs(float64(*order.Accrual-resp.Amount)) > amountTolerance:
+		discrepancy.Kind = entities.DiscrepancyAmountMismatch
+	default:
+		return nil
+	}
+	if !adjust {
+		return discrepancy
+	}
+	if resp.Status != order.Status && !order.Status.CanTransitionTo(resp.Status) {
+		discrepancy.Error = entities.ErrIllegalStatusTransition.Error()
+		return discrepancy
+	}
+	if err := r.adjust(ctx, order, resp, discrepancy.Kind); err != nil {
+		discrepancy.Error = err.Error()
+		return discrepancy
+	}
+	discrepancy.Adjusted = true
+	return discrepancy
+}
+
+func (r *Reconciler) adjust(
+	ctx context.Context,
+	order *entities.ReconciledOrder,
+	resp *entities.AccrualResponse,
+	kind entities.DiscrepancyKind,
+) error {
+	tx, err := r.storage.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := r.orderRepo.UpdateOrderStatus(ctx, tx, order.Number, resp.Status, resp.Raw); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := r.accrualRepo.CreateAccrual(ctx, tx, order.UserID, resp); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := r.accrualRepo.RecordAdjustment(ctx, tx, &entities.AccrualAdjustment{
+		UserID:      order.UserID,
+		OrderNumber: order.Number,
+		OldStatus:   order.Status,
+		NewStatus:   resp.Status,
+		OldAmount:   order.Accrual,
+		NewAmount:   resp.Amount,
+		Reason:      string(kind),
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+// applyListFilter appends the date range, keyset and limit clauses to a query that already has a where clause.
+// One extra row is requested to find out whether there is a next page.
+func applyListFilter(
+	query string, args []any, timeColumn string, idColumn string, filter *entities.ListFilter,
+) (string, []any) {
+	var sb strings.Builder
+	sb.WriteString(query)
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		fmt.Fprintf(&sb, " and %s >= $%d", timeColumn, len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		fmt.Fprintf(&sb, " and %s < $%d", timeColumn, len(args))
+	}
+	direction, comparison := "asc", ">"
+	if filter.Desc {
+		direction, comparison = "desc", "<"
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.At, filter.After.ID)
+		fmt.Fprintf(
+			&sb, " and (%s, %s) %s ($%d, $%d)", timeColumn, idColumn, comparison, len(args)-1, len(args),
+		)
+	}
+	args = append(args, filter.Limit+1)
+	fmt.Fprintf(&sb, " order by %s %s, %s %s limit $%d", timeColumn, direction, idColumn, direction, len(args))
+	return sb.String(), args
+}
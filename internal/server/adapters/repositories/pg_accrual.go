@@ -22,9 +22,13 @@ func NewPGAccrualRepo(logger logging.ILogger, storage entities.Storage) *PGAccru
 	}
 }
 
+func (a *PGAccrualRepo) log(ctx context.Context) logging.ILogger {
+	return logging.FromContext(ctx, a.logger)
+}
+
 func (a *PGAccrualRepo) CreateAccrual(ctx context.Context, tx entities.Tx, userID int, accrual *entities.AccrualResponse) error {
-	a.logger.Infof(
-		"Creating accrual. User: %d, order: %d, amount: %f", userID, accrual.OrderNumber, accrual.Amount,
+	a.log(ctx).Infof(
+		"Creating accrual. User: %d, order: %s, amount: %f", userID, accrual.OrderNumber, accrual.Amount,
 	)
 	query := `
 		insert into accruals(user_id, order_number, amount)
@@ -33,15 +37,62 @@ func (a *PGAccrualRepo) CreateAccrual(ctx context.Context, tx entities.Tx, userI
 		do update set amount = EXCLUDED.amount
 	`
 	if err := tx.ExecContext(ctx, query, userID, accrual.OrderNumber, accrual.Amount); err != nil {
-		a.logger.Errorf("Failed to create accrual: %v", err)
+		a.log(ctx).Errorf("Failed to create accrual: %v", err)
+		return err
+	}
+	a.log(ctx).Infof("Accrual created!")
+	return nil
+}
+
+func (a *PGAccrualRepo) FindAccrualAmount(
+	ctx context.Context, tx entities.Tx, userID int, orderNumber string,
+) (*float32, error) {
+	var amount float32
+	query := "select amount from accruals where user_id = $1 and order_number = $2 and processed_at is null"
+	if err := tx.GetContext(ctx, &amount, query, userID, orderNumber); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		a.log(ctx).Errorf("Failed to find the accrual of order %s: %v", orderNumber, err)
+		return nil, err
+	}
+	return &amount, nil
+}
+
+func (a *PGAccrualRepo) RecordAdjustment(
+	ctx context.Context, tx entities.Tx, adjustment *entities.AccrualAdjustment,
+) error {
+	a.log(ctx).Infof(
+		"Adjusting accrual for order %s: %s -> %s, amount %v -> %f",
+		adjustment.OrderNumber,
+		adjustment.OldStatus,
+		adjustment.NewStatus,
+		adjustment.OldAmount,
+		adjustment.NewAmount,
+	)
+	query := `
+		insert into accrual_adjustments(user_id, order_number, old_status, new_status, old_amount, new_amount, reason)
+		values ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if err := tx.ExecContext(
+		ctx,
+		query,
+		adjustment.UserID,
+		adjustment.OrderNumber,
+		adjustment.OldStatus,
+		adjustment.NewStatus,
+		adjustment.OldAmount,
+		adjustment.NewAmount,
+		adjustment.Reason,
+	); err != nil {
+		a.log(ctx).Errorf("Failed to record the adjustment: %v", err)
 		return err
 	}
-	a.logger.Infof("Accrual created!")
 	return nil
 }
 
 func (a *PGAccrualRepo) GetBalance(ctx context.Context, userID int) (*entities.Balance, error) {
-	a.logger.Infof("Calculating user balance: %d", userID)
+	a.log(ctx).Infof("Calculating user balance: %d", userID)
 	var balance = entities.Balance{}
 	query := `
 		with user_accr as (
@@ -54,52 +105,60 @@ func (a *PGAccrualRepo) GetBalance(ctx context.Context, userID int) (*entities.B
 			(select -1 * coalesce(sum(amount), 0.0) from user_accr where amount < 0) withdrawn
 	`
 	if err := a.storage.GetContext(ctx, &balance, query, userID); err != nil {
-		a.logger.Errorf("Failed to calculate balance: %s", err.Error())
+		a.log(ctx).Errorf("Failed to calculate balance: %s", err.Error())
 		return nil, err
 	}
-	a.logger.Infoln("Balance calculated")
+	a.log(ctx).Infoln("Balance calculated")
 	return &balance, nil
 }
 
 func (a *PGAccrualRepo) CreateWithdrawal(
-	ctx context.Context, withdrawal *entities.Accrual,
+	ctx context.Context, tx entities.Tx, withdrawal *entities.Accrual,
 ) (*entities.Accrual, error) {
-	a.logger.Infof(
-		"Creating withdrawal for user: %d, order: %d, amount: %f",
+	a.log(ctx).Infof(
+		"Creating withdrawal for user: %d, order: %s, amount: %f",
 		withdrawal.UserID,
 		withdrawal.OrderNumber,
 		withdrawal.Amount,
 	)
 	query := "insert into accruals(user_id, order_number, amount, processed_at) values ($1, $2, $3, $4) returning *"
 	var res = entities.Accrual{}
-	if err := a.storage.GetContext(
+	if err := tx.GetContext(
 		ctx, &res, query, withdrawal.UserID, withdrawal.OrderNumber, -withdrawal.Amount, time.Now(),
 	); err != nil {
-		a.logger.Errorf("Failed to create withdrawal: %s", err.Error())
+		a.log(ctx).Errorf("Failed to create withdrawal: %s", err.Error())
 		return nil, err
 	}
-	a.logger.Infof("Withdrawal created!")
+	a.log(ctx).Infof("Withdrawal created!")
 	return &res, nil
 }
 
-func (a *PGAccrualRepo) FindUserWithdrawals(ctx context.Context, userID int) ([]entities.Accrual, error) {
-	a.logger.Infof("Getting user withdrawals: %d", userID)
+func (a *PGAccrualRepo) FindUserWithdrawals(
+	ctx context.Context, userID int, filter *entities.ListFilter,
+) ([]entities.Accrual, *entities.Cursor, error) {
+	a.log(ctx).Infof("Getting user withdrawals: %d", userID)
 	var withdrawals []entities.Accrual
 	query := `
 		select id, user_id, order_number, processed_at, -1 * amount as amount
 		from accruals
 		where user_id = $1
 		and amount < 0
-		order by processed_at
 	`
-	if err := a.storage.SelectContext(ctx, &withdrawals, query, userID); err != nil {
+	query, args := applyListFilter(query, []any{userID}, "processed_at", "id", filter)
+	if err := a.storage.SelectContext(ctx, &withdrawals, query, args...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			a.logger.Infoln("Withdrawals not found")
-			return nil, nil
+			a.log(ctx).Infoln("Withdrawals not found")
+			return nil, nil, nil
 		}
-		a.logger.Errorf("Failed to find the withdrawals: %s", err.Error())
-		return nil, err
+		a.log(ctx).Errorf("Failed to find the withdrawals: %s", err.Error())
+		return nil, nil, err
+	}
+	var next *entities.Cursor
+	if len(withdrawals) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		next = &entities.Cursor{At: last.ProcessedAt.Time, ID: last.ID}
 	}
-	a.logger.Infoln("Withdrawals found")
-	return withdrawals, nil
+	a.log(ctx).Infoln("Withdrawals found")
+	return withdrawals, next, nil
 }
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const outboxRelayLockID = 7_204_315_001
+
+// outboxUserLockClass namespaces the per-user advisory locks taken while appending events
+const outboxUserLockClass = 7_204_315
+
+type PGEventRepo struct {
+	logger  logging.ILogger
+	storage entities.Storage
+}
+
+func NewPGEventRepo(logger logging.ILogger, storage entities.Storage) *PGEventRepo {
+	return &PGEventRepo{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+func (e *PGEventRepo) log(ctx context.Context) logging.ILogger {
+	return logging.FromContext(ctx, e.logger)
+}
+
+func (e *PGEventRepo) AppendEvent(ctx context.Context, tx entities.Tx, event *entities.Event) error {
+	e.log(ctx).Infof("Appending a %s event for user %d to the outbox", event.Type, event.UserID)
+	// Ids are assigned at insert time. Holding the user's lock until commit makes concurrent transactions
+	// of the same user commit in id order, otherwise a client resuming after a later id would miss an event
+	if err := tx.ExecContext(ctx, "select pg_advisory_xact_lock($1, $2)", outboxUserLockClass, event.UserID); err != nil {
+		e.log(ctx).Errorf("Failed to lock the user's outbox: %v", err)
+		return err
+	}
+	query := `
+		insert into event_outbox(user_id, event_type, payload, created_at)
+		values ($1, $2, $3, $4)
+		returning id
+	`
+	if err := tx.GetContext(
+		ctx, &event.ID, query, event.UserID, event.Type, []byte(event.Payload), event.CreatedAt,
+	); err != nil {
+		e.log(ctx).Errorf("Failed to append the event: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (e *PGEventRepo) FindUserEventsAfter(
+	ctx context.Context, userID int, afterID int64, limit int,
+) ([]entities.Event, error) {
+	e.log(ctx).Infof("Searching for user's events after %d: %d", afterID, userID)
+	var events []entities.Event
+	query := `
+		select id, user_id, event_type, payload, created_at
+		from event_outbox
+		where user_id = $1 and id > $2 and published_at is not null
+		order by id
+		limit $3
+	`
+	if err := e.storage.SelectContext(ctx, &events, query, userID, afterID, limit); err != nil {
+		e.log(ctx).Errorf("Failed to find the events: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (e *PGEventRepo) LockRelay(ctx context.Context, tx entities.Tx) (bool, error) {
+	var locked bool
+	if err := tx.GetContext(ctx, &locked, "select pg_try_advisory_xact_lock($1)", outboxRelayLockID); err != nil {
+		e.log(ctx).Errorf("Failed to take the outbox relay lock: %v", err)
+		return false, err
+	}
+	return locked, nil
+}
+
+func (e *PGEventRepo) FetchUnpublishedEvents(ctx context.Context, tx entities.Tx, limit int) ([]entities.Event, error) {
+	var events []entities.Event
+	query := `
+		select id, user_id, event_type, payload, created_at
+		from event_outbox
+		where published_at is null
+		order by id
+		limit $1
+	`
+	if err := tx.SelectContext(ctx, &events, query, limit); err != nil {
+		e.log(ctx).Errorf("Failed to fetch unpublished events: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (e *PGEventRepo) NotifyEvent(ctx context.Context, tx entities.Tx, channel string, event *entities.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := tx.ExecContext(ctx, "select pg_notify($1, $2)", channel, string(data)); err != nil {
+		e.log(ctx).Errorf("Failed to notify about event %d: %v", event.ID, err)
+		return err
+	}
+	return nil
+}
+
+func (e *PGEventRepo) MarkEventsPublished(ctx context.Context, tx entities.Tx, ids []int64) error {
+	if err := tx.ExecContext(ctx, "update event_outbox set published_at = now() where id = any($1)", ids); err != nil {
+		e.log(ctx).Errorf("Failed to mark events as published: %v", err)
+		return err
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/matthiasBT/gophermart/internal/infra/logging"
@@ -22,8 +23,17 @@ func NewPGOrderRepo(logger logging.ILogger, storage entities.Storage) *PGOrderRe
 	}
 }
 
-func (o *PGOrderRepo) CreateOrder(ctx context.Context, userID int, number string) (*entities.Order, bool, error) {
-	o.logger.Infof("Creating order %s for user %d", number, userID)
+// log prefers the request or job scoped logger from the context
+func (o *PGOrderRepo) log(ctx context.Context) logging.ILogger {
+	return logging.FromContext(ctx, o.logger)
+}
+
+func (o *PGOrderRepo) CreateOrder(
+	ctx context.Context, tx entities.Tx, userID int, number string, validator string, provider string,
+) (*entities.Order, bool, error) {
+	o.log(ctx).Infof(
+		"Creating order %s for user %d, validated with %s, accrual provider: %s", number, userID, validator, provider,
+	)
 	order, err := o.FindOrder(ctx, number)
 	if err != nil {
 		return nil, false, err
@@ -32,76 +42,421 @@ func (o *PGOrderRepo) CreateOrder(ctx context.Context, userID int, number string
 		return order, true, nil
 	}
 	var result = entities.Order{}
-	query := "insert into orders(user_id, number, status, uploaded_at) values ($1, $2, $3, $4) returning *"
-	if err := o.storage.GetContext(ctx, &result, query, userID, number, "NEW", time.Now()); err != nil {
-		o.logger.Errorf("Failed to create an order: %s", err.Error())
+	query := `
+		with o as (
+			insert into orders(user_id, number, status, uploaded_at, validator, provider)
+			values ($1, $2, $3, $4, $5, nullif($6, ''))
+			returning *
+		), h as (
+			insert into order_status_history(order_id, status, changed_at)
+			select id, status, uploaded_at from o
+		)
+		select * from o
+	`
+	if err := tx.GetContext(
+		ctx, &result, query, userID, number, entities.OrderStatusNew, time.Now(), validator, provider,
+	); err != nil {
+		o.log(ctx).Errorf("Failed to create an order: %s", err.Error())
 		return nil, false, err
 	}
-	o.logger.Infof("Order created!")
+	o.log(ctx).Infof("Order created!")
 	return &result, false, nil
 }
 
 func (o *PGOrderRepo) FindOrder(ctx context.Context, number string) (*entities.Order, error) {
-	o.logger.Infof("Searching for an order: %d", number)
+	o.log(ctx).Infof("Searching for an order: %s", number)
 	var order = entities.Order{}
-	query := "select * from orders where number = $1"
+	query := `
+		select o.*, coalesce(a.amount, 0) as "accrual"
+		from orders o
+		left join accruals a on o.number = a.order_number and o.user_id = a.user_id and a.processed_at is null
+		where o.number = $1
+	`
 	if err := o.storage.GetContext(ctx, &order, query, number); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			o.logger.Infoln("Order not found")
+			o.log(ctx).Infoln("Order not found")
 			return nil, nil
 		}
-		o.logger.Errorf("Failed to find the order: %s", err.Error())
+		o.log(ctx).Errorf("Failed to find the order: %s", err.Error())
 		return nil, err
 	}
-	o.logger.Infoln("Order found")
+	o.log(ctx).Infoln("Order found")
 	return &order, nil
 }
 
-func (o *PGOrderRepo) FindUserOrders(ctx context.Context, userID int) ([]entities.Order, error) {
-	o.logger.Infof("Searching for user's orders: %d", userID)
+func (o *PGOrderRepo) FindUserOrders(
+	ctx context.Context, userID int, filter *entities.ListFilter,
+) ([]entities.Order, *entities.Cursor, error) {
+	o.log(ctx).Infof("Searching for user's orders: %d", userID)
 	var orders []entities.Order
 	query := `
 		select o.*, coalesce(a.amount, 0) as "accrual"
 		from orders o
-		left join accruals a on o.number = a.order_number
+		left join accruals a on o.number = a.order_number and o.user_id = a.user_id and a.processed_at is null
 		where o.user_id = $1
-		order by uploaded_at
 	`
-	if err := o.storage.SelectContext(ctx, &orders, query, userID); err != nil {
+	args := []any{userID}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, 0, len(filter.Statuses))
+		for _, status := range filter.Statuses {
+			statuses = append(statuses, string(status))
+		}
+		args = append(args, statuses)
+		query += fmt.Sprintf(" and o.status::text = any($%d)", len(args))
+	}
+	query, args = applyListFilter(query, args, "o.uploaded_at", "o.id", filter)
+	if err := o.storage.SelectContext(ctx, &orders, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			o.log(ctx).Infoln("Orders not found")
+			return nil, nil, nil
+		}
+		o.log(ctx).Errorf("Failed to find the orders: %s", err.Error())
+		return nil, nil, err
+	}
+	var next *entities.Cursor
+	if len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		next = &entities.Cursor{At: last.UploadedAt, ID: last.ID}
+	}
+	o.log(ctx).Infoln("Orders found")
+	return orders, next, nil
+}
+
+func (o *PGOrderRepo) FindOrderHistory(ctx context.Context, orderID int) ([]entities.OrderStatusChange, error) {
+	o.log(ctx).Infof("Getting order status history: %d", orderID)
+	var history []entities.OrderStatusChange
+	query := "select * from order_status_history where order_id = $1 order by changed_at, id"
+	if err := o.storage.SelectContext(ctx, &history, query, orderID); err != nil {
+		o.log(ctx).Errorf("Failed to get the order status history: %v", err)
+		return nil, err
+	}
+	o.log(ctx).Infoln("Order status history found")
+	return history, nil
+}
+
+// FetchUnprocessedOrders claims due orders except those of skipProviders. Orders without a provider
+// belong to the default one, which is skipped by an empty name
+func (o *PGOrderRepo) FetchUnprocessedOrders(
+	ctx context.Context, owner string, lease time.Duration, limit int, skipProviders []string,
+) ([]entities.Order, error) {
+	o.log(ctx).Infof("Claiming %d unprocessed orders for %s", limit, owner)
+	var orders []entities.Order
+	query := `
+		with claimed as (
+			select id
+			from orders
+			where (status not in ($1, $2) or force_repoll)
+			and stuck_at is null
+			and dead_at is null
+			and next_attempt_at <= $3
+			and (lease_until is null or lease_until < $3)
+			and coalesce(provider, '') <> all($7)
+			order by next_attempt_at
+			limit $6
+			for update skip locked
+		)
+		update orders o
+		set locked_by = $4, lease_until = $5
+		from claimed
+		where o.id = claimed.id
+		returning o.*
+	`
+	now := time.Now()
+	if err := o.storage.SelectContext(
+		ctx,
+		&orders,
+		query,
+		entities.OrderStatusInvalid,
+		entities.OrderStatusProcessed,
+		now,
+		owner,
+		now.Add(lease),
+		limit,
+		append([]string{}, skipProviders...),
+	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			o.logger.Infoln("Orders not found")
+			o.log(ctx).Infoln("Orders not found")
 			return nil, nil
 		}
-		o.logger.Errorf("Failed to find the orders: %s", err.Error())
+		o.log(ctx).Errorf("Failed to claim the orders: %v", err)
 		return nil, err
 	}
-	o.logger.Infoln("Orders found")
+	o.log(ctx).Infof("Claimed %d unprocessed orders", len(orders))
 	return orders, nil
 }
 
-func (o *PGOrderRepo) FetchUnprocessedOrders(ctx context.Context, limit int) ([]entities.Order, error) {
-	o.logger.Infof("Getting %d unprocessed orders", limit)
+func (o *PGOrderRepo) RenewLease(ctx context.Context, number string, owner string, lease time.Duration) (bool, error) {
+	o.log(ctx).Infof("Renewing the lease on order %s for %s", number, owner)
+	var renewed []int
+	query := "update orders set lease_until = $1 where number = $2 and locked_by = $3 returning id"
+	if err := o.storage.SelectContext(ctx, &renewed, query, time.Now().Add(lease), number, owner); err != nil {
+		o.log(ctx).Errorf("Failed to renew the lease: %v", err)
+		return false, err
+	}
+	return len(renewed) > 0, nil
+}
+
+func (o *PGOrderRepo) ReleaseOrder(ctx context.Context, number string, owner string) error {
+	o.log(ctx).Infof("Releasing order %s held by %s", number, owner)
+	query := "update orders set locked_by = null, lease_until = null where number = $1 and locked_by = $2"
+	if err := o.storage.ExecContext(ctx, query, number, owner); err != nil {
+		o.log(ctx).Errorf("Failed to release the order: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (o *PGOrderRepo) NotifyOrderCreated(ctx context.Context, tx entities.Tx, channel string, number string) error {
+	if err := tx.ExecContext(ctx, "select pg_notify($1, $2)", channel, number); err != nil {
+		o.log(ctx).Errorf("Failed to notify about order %s: %v", number, err)
+		return err
+	}
+	return nil
+}
+
+func (o *PGOrderRepo) ScheduleNextAttempt(ctx context.Context, number string, nextAttemptAt time.Time) error {
+	o.log(ctx).Infof("Scheduling the next accrual request for order %s at %v", number, nextAttemptAt)
+	query := "update orders set attempts = attempts + 1, next_attempt_at = $1 where number = $2"
+	if err := o.storage.ExecContext(ctx, query, nextAttemptAt, number); err != nil {
+		o.log(ctx).Errorf("Failed to schedule the next attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+// PostponeAttempt moves the next accrual request without counting an attempt
+func (o *PGOrderRepo) PostponeAttempt(ctx context.Context, number string, nextAttemptAt time.Time) error {
+	o.log(ctx).Infof("Postponing the next accrual request for order %s to %v", number, nextAttemptAt)
+	query := "update orders set next_attempt_at = $1 where number = $2"
+	if err := o.storage.ExecContext(ctx, query, nextAttemptAt, number); err != nil {
+		o.log(ctx).Errorf("Failed to postpone the next attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (o *PGOrderRepo) MarkStuckOrders(ctx context.Context, uploadedBefore time.Time) ([]entities.Order, error) {
+	o.log(ctx).Infof("Marking orders uploaded before %v as stuck", uploadedBefore)
+	var orders []entities.Order
+	query := `
+		update orders set stuck_at = $1
+		where status not in ($2, $3)
+		and stuck_at is null
+		and coalesce(requeued_at, uploaded_at) < $4
+		returning *
+	`
+	if err := o.storage.SelectContext(
+		ctx, &orders, query, time.Now(), entities.OrderStatusInvalid, entities.OrderStatusProcessed, uploadedBefore,
+	); err != nil {
+		o.log(ctx).Errorf("Failed to mark stuck orders: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o *PGOrderRepo) FindStuckOrders(ctx context.Context, limit int) ([]entities.Order, error) {
+	o.log(ctx).Infoln("Searching for stuck orders")
 	var orders []entities.Order
-	query := "select * from orders where status not in ('INVALID', 'PROCESSED') order by id limit $1"
+	query := "select * from orders where stuck_at is not null order by stuck_at desc limit $1"
 	if err := o.storage.SelectContext(ctx, &orders, query, limit); err != nil {
+		o.log(ctx).Errorf("Failed to find stuck orders: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o *PGOrderRepo) UpdateOrderStatus(
+	ctx context.Context, tx entities.Tx, number string, status entities.OrderStatus, cause []byte,
+) (bool, error) {
+	o.log(ctx).Infof("Updating order %s status: %s", number, status)
+	if _, err := entities.ParseOrderStatus(string(status)); err != nil {
+		o.log(ctx).Errorf("Refusing to update order %s: %v", number, err)
+		return false, err
+	}
+	var current entities.OrderStatus
+	if err := tx.GetContext(ctx, &current, "select status from orders where number = $1 for update", number); err != nil {
+		o.log(ctx).Errorf("Failed to lock the order: %v", err)
+		return false, err
+	}
+	if current == status {
+		o.log(ctx).Infof("Order %s status is unchanged", number)
+		return false, nil
+	}
+	if !current.CanTransitionTo(status) {
+		o.log(ctx).Warningf("Illegal order %s status transition: %s -> %s", number, current, status)
+		return false, fmt.Errorf("%w: %s -> %s", entities.ErrIllegalStatusTransition, current, status)
+	}
+	query := `
+		with upd as (
+			update orders set status = $1
+			where number = $2
+			returning id, status
+		)
+		insert into order_status_history(order_id, status, accrual_response, changed_at)
+		select id, status, $3, $4 from upd
+	`
+	if err := tx.ExecContext(ctx, query, status, number, cause, time.Now()); err != nil {
+		o.log(ctx).Errorf("Failed to update order: %v", err)
+		return false, err
+	}
+	o.log(ctx).Infof("Order status updated!")
+	return true, nil
+}
+
+func (o *PGOrderRepo) RecordFailure(
+	ctx context.Context, number string, reason string, maxFailures int,
+) (*entities.Order, error) {
+	o.log(ctx).Infof("Recording a failure of order %s: %s", number, reason)
+	var order = entities.Order{}
+	query := `
+		with o as (
+			update orders
+			set failures = failures + 1,
+				last_error = $2,
+				dead_at = case when failures + 1 >= $3 then $4 else dead_at end
+			where number = $1
+			returning *
+		), f as (
+			insert into order_failures(order_id, reason, failed_at)
+			select id, $2, $4 from o
+		)
+		select * from o
+	`
+	if err := o.storage.GetContext(ctx, &order, query, number, reason, maxFailures, time.Now()); err != nil {
+		o.log(ctx).Errorf("Failed to record the failure: %v", err)
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (o *PGOrderRepo) FinishRepoll(ctx context.Context, tx entities.Tx, number string) error {
+	query := "update orders set force_repoll = false where number = $1 and force_repoll"
+	if err := tx.ExecContext(ctx, query, number); err != nil {
+		o.log(ctx).Errorf("Failed to finish the re-poll of order %s: %v", number, err)
+		return err
+	}
+	return nil
+}
+
+func (o *PGOrderRepo) FindFailedOrders(
+	ctx context.Context, filter *entities.FailedOrderFilter,
+) ([]entities.Order, error) {
+	o.log(ctx).Infof("Searching for failed orders: %+v", *filter)
+	var orders []entities.Order
+	query, args := applyFailedOrderFilter("select * from orders", nil, filter)
+	if err := o.storage.SelectContext(ctx, &orders, query, args...); err != nil {
+		o.log(ctx).Errorf("Failed to find failed orders: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o *PGOrderRepo) FindOrderFailures(ctx context.Context, orderID int) ([]entities.OrderFailure, error) {
+	o.log(ctx).Infof("Searching for failures of order %d", orderID)
+	var failures []entities.OrderFailure
+	query := "select * from order_failures where order_id = $1 order by failed_at"
+	if err := o.storage.SelectContext(ctx, &failures, query, orderID); err != nil {
+		o.log(ctx).Errorf("Failed to find order failures: %v", err)
+		return nil, err
+	}
+	return failures, nil
+}
+
+func (o *PGOrderRepo) RequeueOrder(ctx context.Context, number string, force bool) (*entities.Order, error) {
+	o.log(ctx).Infof("Requeueing order %s, forced: %v", number, force)
+	var order = entities.Order{}
+	query := requeueQuery + " where number = $3 returning *"
+	if err := o.storage.GetContext(ctx, &order, query, time.Now(), force, number); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			o.logger.Infoln("Orders not found")
+			o.log(ctx).Infoln("Order not found")
 			return nil, nil
 		}
-		o.logger.Errorf("Failed to find the orders: %v", err)
+		o.log(ctx).Errorf("Failed to requeue the order: %v", err)
 		return nil, err
 	}
-	o.logger.Infoln("Unprocessed orders found")
+	return &order, nil
+}
+
+func (o *PGOrderRepo) RequeueFailedOrders(
+	ctx context.Context, filter *entities.FailedOrderFilter,
+) ([]entities.Order, error) {
+	o.log(ctx).Infof("Requeueing failed orders: %+v", *filter)
+	var orders []entities.Order
+	selection, args := applyFailedOrderFilter("select id from orders", []any{time.Now(), false}, filter)
+	query := requeueQuery + " where id in (" + selection + ") returning *"
+	if err := o.storage.SelectContext(ctx, &orders, query, args...); err != nil {
+		o.log(ctx).Errorf("Failed to requeue failed orders: %v", err)
+		return nil, err
+	}
+	o.log(ctx).Infof("Requeued %d orders", len(orders))
 	return orders, nil
 }
 
-func (o *PGOrderRepo) UpdateOrderStatus(ctx context.Context, tx entities.Tx, number string, status string) error {
-	o.logger.Infof("Updating order %s status: %s", number, status)
-	query := "update orders set status = $1 where number = $2"
-	if err := tx.ExecContext(ctx, query, status, number); err != nil {
-		o.logger.Errorf("Failed to update order: %v", err)
-		return err
+const requeueQuery = `
+	update orders
+	set failures = 0,
+		last_error = null,
+		dead_at = null,
+		stuck_at = null,
+		attempts = 0,
+		next_attempt_at = $1,
+		requeued_at = $1,
+		force_repoll = $2 and status = 'PROCESSED'
+`
+
+func applyFailedOrderFilter(query string, args []any, filter *entities.FailedOrderFilter) (string, []any) {
+	query += " where failures > 0"
+	if filter.DeadOnly {
+		query += " and dead_at is not null"
 	}
-	o.logger.Infof("Order status updated!")
-	return nil
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, 0, len(filter.Statuses))
+		for _, status := range filter.Statuses {
+			statuses = append(statuses, string(status))
+		}
+		args = append(args, statuses)
+		query += fmt.Sprintf(" and status::text = any($%d)", len(args))
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" order by id limit $%d", len(args))
+	return query, args
+}
+
+func (o *PGOrderRepo) FindOrdersForReconciliation(
+	ctx context.Context, from time.Time, to time.Time, limit int,
+) ([]entities.ReconciledOrder, error) {
+	o.log(ctx).Infof("Searching for orders uploaded between %v and %v to reconcile", from, to)
+	var orders []entities.ReconciledOrder
+	query := `
+		select o.number, o.user_id, coalesce(o.provider, '') as "provider", o.status, a.amount as "accrual",
+			o.uploaded_at
+		from orders o
+		left join accruals a on o.number = a.order_number and o.user_id = a.user_id and a.processed_at is null
+		where o.uploaded_at >= $1 and o.uploaded_at < $2
+		order by o.uploaded_at, o.id
+		limit $3
+	`
+	if err := o.storage.SelectContext(ctx, &orders, query, from, to, limit); err != nil {
+		o.log(ctx).Errorf("Failed to find orders to reconcile: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o *PGOrderRepo) CountOrdersByStatus(ctx context.Context) (map[entities.OrderStatus]int, error) {
+	var rows []struct {
+		Status entities.OrderStatus `db:"status"`
+		Count  int                  `db:"count"`
+	}
+	query := "select status, count(*) as count from orders group by status"
+	if err := o.storage.SelectContext(ctx, &rows, query); err != nil {
+		o.log(ctx).Errorf("Failed to count orders by status: %v", err)
+		return nil, err
+	}
+	counts := make(map[entities.OrderStatus]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
 }
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+// statusTx returns the current order status from GetContext and records executed statements
+type statusTx struct {
+	current entities.OrderStatus
+	execs   int
+}
+
+func (tx *statusTx) Commit() error   { return nil }
+func (tx *statusTx) Rollback() error { return nil }
+
+func (tx *statusTx) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	*dest.(*entities.OrderStatus) = tx.current
+	return nil
+}
+
+func (tx *statusTx) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return errors.New("unexpected select")
+}
+
+func (tx *statusTx) ExecContext(ctx context.Context, query string, args ...any) error {
+	tx.execs++
+	return nil
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	logger, err := logging.SetupLogger("panic", logging.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewPGOrderRepo(logger, nil)
+	tests := []struct {
+		name        string
+		current     entities.OrderStatus
+		next        entities.OrderStatus
+		wantChanged bool
+		wantErr     error
+	}{
+		{"new to processing", entities.OrderStatusNew, entities.OrderStatusProcessing, true, nil},
+		{"processing to processed", entities.OrderStatusProcessing, entities.OrderStatusProcessed, true, nil},
+		{"unchanged", entities.OrderStatusProcessing, entities.OrderStatusProcessing, false, nil},
+		{"final unchanged", entities.OrderStatusProcessed, entities.OrderStatusProcessed, false, nil},
+		{"backwards", entities.OrderStatusProcessing, entities.OrderStatusRegistered, false, entities.ErrIllegalStatusTransition},
+		{"from final", entities.OrderStatusInvalid, entities.OrderStatusProcessed, false, entities.ErrIllegalStatusTransition},
+		{"unknown status", entities.OrderStatusNew, "DONE", false, entities.ErrUnknownOrderStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &statusTx{current: tt.current}
+			changed, err := repo.UpdateOrderStatus(context.Background(), tx, "12345678903", tt.next, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrderStatus() error = %v, want %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("UpdateOrderStatus() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if wantExecs := map[bool]int{true: 1, false: 0}[tt.wantChanged]; tx.execs != wantExecs {
+				t.Errorf("executed %d statements, want %d", tx.execs, wantExecs)
+			}
+		})
+	}
+}
@@ -25,68 +25,87 @@ func NewPGUserRepo(logger logging.ILogger, storage entities.Storage) *PGUserRepo
 	}
 }
 
+func (r *PGUserRepo) log(ctx context.Context) logging.ILogger {
+	return logging.FromContext(ctx, r.logger)
+}
+
 func (r *PGUserRepo) CreateUser(
 	ctx context.Context, tx entities.Tx, login string, pwdhash []byte,
 ) (*entities.User, error) {
-	r.logger.Infof("Creating a new user: %s", login)
+	r.log(ctx).Infof("Creating a new user: %s", login)
 	var user = entities.User{}
 	query := "insert into users(login, password_hash) values ($1, $2) returning *"
 	if err := tx.GetContext(ctx, &user, query, login, pwdhash); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			r.logger.Infof("Login is already taken")
+			r.log(ctx).Infof("Login is already taken")
 			return nil, entities.ErrLoginAlreadyTaken
 		}
-		r.logger.Errorf("Failed to create a user record: %s", err.Error())
+		r.log(ctx).Errorf("Failed to create a user record: %s", err.Error())
 		return nil, err
 	}
-	r.logger.Infof("User created: %s", login)
+	r.log(ctx).Infof("User created: %s", login)
 	return &user, nil
 }
 
 func (r *PGUserRepo) CreateSession(
 	ctx context.Context, tx entities.Tx, user *entities.User, token string,
 ) (*entities.Session, error) {
-	r.logger.Infof("Creating a session for a user: %s", user.Login)
+	r.log(ctx).Infof("Creating a session for a user: %s", user.Login)
 	var session = entities.Session{}
 	query := "insert into sessions(user_id, token, expires_at) values ($1, $2, $3) returning *"
 	expiresAt := time.Now().Add(config.SessionTTL)
 	if err := tx.GetContext(ctx, &session, query, user.ID, token, expiresAt); err != nil {
-		r.logger.Errorf("Failed to create a user session: %s", err.Error())
+		r.log(ctx).Errorf("Failed to create a user session: %s", err.Error())
 		return nil, err
 	}
-	r.logger.Infof("Session created!")
+	r.log(ctx).Infof("Session created!")
 	return &session, nil
 }
 
 func (r *PGUserRepo) FindUser(ctx context.Context, request *entities.UserAuthRequest) (*entities.User, error) {
-	r.logger.Infof("Searching for a user: %s", request.Login)
+	r.log(ctx).Infof("Searching for a user: %s", request.Login)
 	var user = entities.User{}
 	query := "select * from users where login = $1"
 	if err := r.storage.GetContext(ctx, &user, query, request.Login); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			r.logger.Infoln("User not found")
+			r.log(ctx).Infoln("User not found")
 			return nil, nil
 		}
-		r.logger.Errorf("Failed to find the user: %s", err.Error())
+		r.log(ctx).Errorf("Failed to find the user: %s", err.Error())
 		return nil, err
 	}
-	r.logger.Infoln("User found")
+	r.log(ctx).Infoln("User found")
 	return &user, nil
 }
 
 func (r *PGUserRepo) FindSession(ctx context.Context, token string) (*entities.Session, error) {
-	r.logger.Infof("Looking for a session")
+	r.log(ctx).Infof("Looking for a session")
 	var session = entities.Session{}
-	query := "select * from sessions where token = $1"
+	query := "select s.*, u.partner from sessions s join users u on u.id = s.user_id where s.token = $1"
 	if err := r.storage.GetContext(ctx, &session, query, token); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			r.logger.Infoln("Session not found")
+			r.log(ctx).Infoln("Session not found")
 			return nil, nil
 		}
-		r.logger.Errorf("Failed to find the session: %s", err.Error())
+		r.log(ctx).Errorf("Failed to find the session: %s", err.Error())
 		return nil, err
 	}
-	r.logger.Infoln("Session found")
+	r.log(ctx).Infoln("Session found")
 	return &session, nil
 }
+
+func (r *PGUserRepo) SetPartner(ctx context.Context, login string, partner *string) (*entities.User, error) {
+	r.log(ctx).Infof("Assigning user %s to a partner", login)
+	var user = entities.User{}
+	query := "update users set partner = $1 where login = $2 returning *"
+	if err := r.storage.GetContext(ctx, &user, query, partner, login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.log(ctx).Infoln("User not found")
+			return nil, nil
+		}
+		r.log(ctx).Errorf("Failed to assign the user to a partner: %s", err.Error())
+		return nil, err
+	}
+	return &user, nil
+}
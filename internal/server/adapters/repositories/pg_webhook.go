@@ -0,0 +1,199 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type PGWebhookRepo struct {
+	logger  logging.ILogger
+	storage entities.Storage
+}
+
+func NewPGWebhookRepo(logger logging.ILogger, storage entities.Storage) *PGWebhookRepo {
+	return &PGWebhookRepo{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+func (wr *PGWebhookRepo) log(ctx context.Context) logging.ILogger {
+	return logging.FromContext(ctx, wr.logger)
+}
+
+func (wr *PGWebhookRepo) CreateSubscription(
+	ctx context.Context, subscription *entities.WebhookSubscription,
+) (*entities.WebhookSubscription, error) {
+	wr.log(ctx).Infof("Creating a webhook subscription for user %d: %s", subscription.UserID, subscription.URL)
+	var result = entities.WebhookSubscription{}
+	query := `
+		insert into webhook_subscriptions(user_id, url, secret, event_types, created_at)
+		values ($1, $2, $3, $4, $5)
+		returning *
+	`
+	if err := wr.storage.GetContext(
+		ctx,
+		&result,
+		query,
+		subscription.UserID,
+		subscription.URL,
+		subscription.Secret,
+		subscription.EventTypes,
+		time.Now(),
+	); err != nil {
+		wr.log(ctx).Errorf("Failed to create a webhook subscription: %v", err)
+		return nil, err
+	}
+	wr.log(ctx).Infoln("Webhook subscription created!")
+	return &result, nil
+}
+
+func (wr *PGWebhookRepo) FindUserSubscriptions(
+	ctx context.Context, userID int,
+) ([]entities.WebhookSubscription, error) {
+	wr.log(ctx).Infof("Searching for user's webhook subscriptions: %d", userID)
+	var subscriptions []entities.WebhookSubscription
+	query := "select * from webhook_subscriptions where user_id = $1 order by id"
+	if err := wr.storage.SelectContext(ctx, &subscriptions, query, userID); err != nil {
+		wr.log(ctx).Errorf("Failed to find the webhook subscriptions: %v", err)
+		return nil, err
+	}
+	wr.log(ctx).Infoln("Webhook subscriptions found")
+	return subscriptions, nil
+}
+
+func (wr *PGWebhookRepo) DeleteSubscription(ctx context.Context, userID int, id int) error {
+	wr.log(ctx).Infof("Deleting webhook subscription %d of user %d", id, userID)
+	var deleted int
+	query := "delete from webhook_subscriptions where id = $1 and user_id = $2 returning id"
+	if err := wr.storage.GetContext(ctx, &deleted, query, id, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			wr.log(ctx).Infoln("Webhook subscription not found")
+			return entities.ErrWebhookNotFound
+		}
+		wr.log(ctx).Errorf("Failed to delete the webhook subscription: %v", err)
+		return err
+	}
+	wr.log(ctx).Infoln("Webhook subscription deleted!")
+	return nil
+}
+
+func (wr *PGWebhookRepo) EnqueueEvent(ctx context.Context, tx entities.Tx, event *entities.Event) error {
+	wr.log(ctx).Infof("Enqueueing %s webhooks for user %d", event.Type, event.UserID)
+	query := `
+		insert into webhook_deliveries(subscription_id, event_type, payload, status, next_attempt_at, created_at)
+		select id, $2, $3, $4, $5, $5
+		from webhook_subscriptions
+		where user_id = $1 and event_types @> jsonb_build_array($2::text)
+	`
+	if err := tx.ExecContext(
+		ctx, query, event.UserID, event.Type, []byte(event.Payload), entities.WebhookDeliveryPending, event.CreatedAt,
+	); err != nil {
+		wr.log(ctx).Errorf("Failed to enqueue webhooks: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (wr *PGWebhookRepo) FindUserDeliveries(
+	ctx context.Context, userID int, status entities.WebhookDeliveryStatus, limit int,
+) ([]entities.WebhookDelivery, error) {
+	wr.log(ctx).Infof("Searching for user's webhook deliveries: %d, status: %s", userID, status)
+	var deliveries []entities.WebhookDelivery
+	query := `
+		select d.*, s.url, s.secret
+		from webhook_deliveries d
+		join webhook_subscriptions s on s.id = d.subscription_id
+		where s.user_id = $1 and ($2 = '' or d.status::text = $2)
+		order by d.id desc
+		limit $3
+	`
+	if err := wr.storage.SelectContext(ctx, &deliveries, query, userID, status, limit); err != nil {
+		wr.log(ctx).Errorf("Failed to find the webhook deliveries: %v", err)
+		return nil, err
+	}
+	wr.log(ctx).Infoln("Webhook deliveries found")
+	return deliveries, nil
+}
+
+func (wr *PGWebhookRepo) Redeliver(ctx context.Context, userID int, id int64) error {
+	wr.log(ctx).Infof("Scheduling webhook delivery %d of user %d for redelivery", id, userID)
+	var updated int64
+	query := `
+		update webhook_deliveries d
+		set status = $3, attempts = 0, next_attempt_at = $4, last_error = null, delivered_at = null
+		from webhook_subscriptions s
+		where d.id = $1 and s.id = d.subscription_id and s.user_id = $2
+		returning d.id
+	`
+	if err := wr.storage.GetContext(
+		ctx, &updated, query, id, userID, entities.WebhookDeliveryPending, time.Now(),
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			wr.log(ctx).Infoln("Webhook delivery not found")
+			return entities.ErrWebhookNotFound
+		}
+		wr.log(ctx).Errorf("Failed to schedule the redelivery: %v", err)
+		return err
+	}
+	wr.log(ctx).Infoln("Webhook delivery scheduled!")
+	return nil
+}
+
+func (wr *PGWebhookRepo) ClaimDueDeliveries(
+	ctx context.Context, lease time.Duration, limit int,
+) ([]entities.WebhookDelivery, error) {
+	wr.log(ctx).Infof("Claiming up to %d due webhook deliveries", limit)
+	var deliveries []entities.WebhookDelivery
+	query := `
+		with due as (
+			select id
+			from webhook_deliveries
+			where status = $1 and next_attempt_at <= $2
+			order by next_attempt_at
+			limit $4
+			for update skip locked
+		)
+		update webhook_deliveries d
+		set next_attempt_at = $3, attempts = d.attempts + 1
+		from due, webhook_subscriptions s
+		where d.id = due.id and s.id = d.subscription_id
+		returning d.*, s.url, s.secret
+	`
+	now := time.Now()
+	if err := wr.storage.SelectContext(
+		ctx, &deliveries, query, entities.WebhookDeliveryPending, now, now.Add(lease), limit,
+	); err != nil {
+		wr.log(ctx).Errorf("Failed to claim webhook deliveries: %v", err)
+		return nil, err
+	}
+	wr.log(ctx).Infof("Claimed %d webhook deliveries", len(deliveries))
+	return deliveries, nil
+}
+
+func (wr *PGWebhookRepo) MarkDelivered(ctx context.Context, id int64) error {
+	wr.log(ctx).Infof("Marking webhook delivery %d as delivered", id)
+	query := "update webhook_deliveries set status = $1, delivered_at = $2, last_error = null where id = $3"
+	if err := wr.storage.ExecContext(ctx, query, entities.WebhookDeliveryDelivered, time.Now(), id); err != nil {
+		wr.log(ctx).Errorf("Failed to mark the webhook delivery: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (wr *PGWebhookRepo) MarkFailed(
+	ctx context.Context, id int64, reason string, status entities.WebhookDeliveryStatus, nextAttemptAt time.Time,
+) error {
+	wr.log(ctx).Infof("Marking webhook delivery %d as failed: %s", id, status)
+	query := "update webhook_deliveries set status = $1, last_error = $2, next_attempt_at = $3 where id = $4"
+	if err := wr.storage.ExecContext(ctx, query, status, reason, nextAttemptAt, id); err != nil {
+		wr.log(ctx).Errorf("Failed to mark the webhook delivery: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type drainContextKey struct{}
+
+// drainContext returns the context in-flight work should use: unlike the worker context,
+// it stays alive after Stop until the drain deadline passes
+func drainContext(ctx context.Context) context.Context {
+	if work, ok := ctx.Value(drainContextKey{}).(context.Context); ok {
+		return work
+	}
+	return ctx
+}
+
+type supervisedWorker struct {
+	run    func(ctx context.Context)
+	ctx    context.Context
+	status entities.WorkerStatus
+}
+
+// Supervisor owns background workers: it restarts them after a panic and stops them gracefully
+type Supervisor struct {
+	logger       logging.ILogger
+	restartDelay time.Duration
+	lock         sync.Mutex
+	workers      []*supervisedWorker
+	wg           sync.WaitGroup
+	ctx          context.Context
+	stop         context.CancelFunc
+	abort        context.CancelFunc
+}
+
+func NewSupervisor(ctx context.Context, logger logging.ILogger, restartDelay time.Duration) *Supervisor {
+	work, abort := context.WithCancel(ctx)
+	ctx, stop := context.WithCancel(context.WithValue(work, drainContextKey{}, work))
+	return &Supervisor{
+		logger:       logger,
+		restartDelay: restartDelay,
+		ctx:          ctx,
+		stop:         stop,
+		abort:        abort,
+	}
+}
+
+// Go launches a supervised worker. The returned function stops this worker alone, e.g. when shrinking a pool
+func (s *Supervisor) Go(name string, run func(ctx context.Context)) context.CancelFunc {
+	ctx, cancel := context.WithCancel(s.ctx)
+	worker := &supervisedWorker{
+		run:    run,
+		ctx:    ctx,
+		status: entities.WorkerStatus{Name: name, State: entities.WorkerRunning, StartedAt: time.Now()},
+	}
+	s.lock.Lock()
+	s.workers = append(s.workers, worker)
+	s.lock.Unlock()
+	s.wg.Add(1)
+	go s.supervise(worker)
+	return cancel
+}
+
+func (s *Supervisor) supervise(worker *supervisedWorker) {
+	defer s.wg.Done()
+	defer s.exit(worker)
+	for {
+		recovered := s.runSafely(worker)
+		if recovered == nil || worker.ctx.Err() != nil {
+			return
+		}
+		s.logger.Errorf("Worker %s panicked, restarting: %v", worker.status.Name, recovered)
+		s.lock.Lock()
+		worker.status.State = entities.WorkerRestarting
+		worker.status.Restarts++
+		worker.status.LastPanic = fmt.Sprint(recovered)
+		s.lock.Unlock()
+		select {
+		case <-worker.ctx.Done():
+			return
+		case <-time.After(s.restartDelay):
+		}
+		s.lock.Lock()
+		worker.status.State = entities.WorkerRunning
+		worker.status.StartedAt = time.Now()
+		s.lock.Unlock()
+	}
+}
+
+func (s *Supervisor) runSafely(worker *supervisedWorker) (recovered any) {
+	defer func() {
+		if recovered = recover(); recovered != nil {
+			s.logger.Errorf("Worker %s stack trace: %s", worker.status.Name, debug.Stack())
+		}
+	}()
+	worker.run(worker.ctx)
+	return nil
+}
+
+// exit marks the worker as stopped. Workers stopped individually are forgotten, the rest stay visible
+func (s *Supervisor) exit(worker *supervisedWorker) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	worker.status.State = entities.WorkerStopped
+	if s.ctx.Err() != nil || worker.ctx.Err() == nil {
+		return
+	}
+	for i, w := range s.workers {
+		if w == worker {
+			s.workers = append(s.workers[:i], s.workers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Supervisor) Statuses() []entities.WorkerStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	statuses := make([]entities.WorkerStatus, 0, len(s.workers))
+	for _, worker := range s.workers {
+		statuses = append(statuses, worker.status)
+	}
+	return statuses
+}
+
+// Stop asks the workers to finish and waits for in-flight work until the timeout,
+// then aborts whatever is still running. It reports whether everything drained in time
+func (s *Supervisor) Stop(timeout time.Duration) bool {
+	s.logger.Infoln("Stopping the workers")
+	s.stop()
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		s.logger.Infoln("All workers stopped")
+		s.abort()
+		return true
+	case <-time.After(timeout):
+		s.logger.Warningf("Workers didn't stop in %v, aborting in-flight work", timeout)
+		s.abort()
+		<-stopped
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/matthiasBT/gophermart/internal/infra/tracing"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedStorage wraps every query in a client span. Queries issued within a transaction
+// become children of the transaction span, which ends on commit or rollback.
+type TracedStorage struct {
+	storage entities.Storage
+}
+
+func NewTracedStorage(storage entities.Storage) *TracedStorage {
+	return &TracedStorage{storage: storage}
+}
+
+type tracedTx struct {
+	tx   entities.Tx
+	span trace.Span
+}
+
+func startQuerySpan(ctx context.Context, name string, query string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query)),
+	)
+}
+
+// recordQueryError doesn't fail the span on sql.ErrNoRows, repositories treat it as a regular outcome
+func recordQueryError(span trace.Span, err error) {
+	if !errors.Is(err, sql.ErrNoRows) {
+		tracing.RecordError(span, err)
+	}
+}
+
+func (st *TracedStorage) Tx(ctx context.Context) (entities.Tx, error) {
+	ctx, span := tracing.Tracer().Start(
+		ctx, "db.tx", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	tx, err := st.storage.Tx(ctx)
+	if err != nil {
+		recordQueryError(span, err)
+		span.End()
+		return nil, err
+	}
+	return &tracedTx{tx: tx, span: span}, nil
+}
+
+func (st *TracedStorage) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, span := startQuerySpan(ctx, "db.select", query)
+	defer span.End()
+	err := st.storage.SelectContext(ctx, dest, query, args...)
+	recordQueryError(span, err)
+	return err
+}
+
+func (st *TracedStorage) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, span := startQuerySpan(ctx, "db.get", query)
+	defer span.End()
+	err := st.storage.GetContext(ctx, dest, query, args...)
+	recordQueryError(span, err)
+	return err
+}
+
+func (st *TracedStorage) ExecContext(ctx context.Context, query string, args ...any) error {
+	ctx, span := startQuerySpan(ctx, "db.exec", query)
+	defer span.End()
+	err := st.storage.ExecContext(ctx, query, args...)
+	recordQueryError(span, err)
+	return err
+}
+
+func (tx *tracedTx) Commit() error {
+	defer tx.span.End()
+	err := tx.tx.Commit()
+	tracing.RecordError(tx.span, err)
+	return err
+}
+
+func (tx *tracedTx) Rollback() error {
+	defer tx.span.End()
+	tx.span.AddEvent("rollback")
+	return tx.tx.Rollback()
+}
+
+func (tx *tracedTx) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, span := startQuerySpan(trace.ContextWithSpan(ctx, tx.span), "db.get", query)
+	defer span.End()
+	err := tx.tx.GetContext(ctx, dest, query, args...)
+	recordQueryError(span, err)
+	return err
+}
+
+func (tx *tracedTx) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, span := startQuerySpan(trace.ContextWithSpan(ctx, tx.span), "db.select", query)
+	defer span.End()
+	err := tx.tx.SelectContext(ctx, dest, query, args...)
+	recordQueryError(span, err)
+	return err
+}
+
+func (tx *tracedTx) ExecContext(ctx context.Context, query string, args ...any) error {
+	ctx, span := startQuerySpan(trace.ContextWithSpan(ctx, tx.span), "db.exec", query)
+	defer span.End()
+	err := tx.tx.ExecContext(ctx, query, args...)
+	recordQueryError(span, err)
+	return err
+}
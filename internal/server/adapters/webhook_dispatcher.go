@@ -0,0 +1,155 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/infra/netguard"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+const WebhookSignatureHeader = "X-Gophermart-Signature"
+
+type webhookBody struct {
+	DeliveryID int64              `json:"delivery_id"`
+	Event      entities.EventType `json:"event"`
+	CreatedAt  string             `json:"created_at"`
+	Data       json.RawMessage    `json:"data"`
+}
+
+type WebhookDispatcher struct {
+	repo        entities.WebhookRepo
+	client      *http.Client
+	logger      logging.ILogger
+	tick        <-chan time.Time
+	batchSize   int
+	lease       time.Duration
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func NewWebhookDispatcher(
+	repo entities.WebhookRepo,
+	logger logging.ILogger,
+	tick <-chan time.Time,
+	batchSize int,
+	timeout time.Duration,
+	maxAttempts int,
+	baseDelay time.Duration,
+	maxDelay time.Duration,
+) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repo:        repo,
+		client:      newWebhookClient(timeout),
+		logger:      logger,
+		tick:        tick,
+		batchSize:   batchSize,
+		lease:       timeout * time.Duration(batchSize),
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+	}
+}
+
+// newWebhookClient only connects to public addresses, so that subscribers can't reach internal services.
+// Proxies are disabled as they would make the check apply to the proxy instead of the target
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: netguard.DialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	d.logger.Infoln("Launching the webhook dispatcher")
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Infoln("Stopping the webhook dispatcher")
+			return
+		case <-d.tick:
+			if err := d.dispatch(drainContext(ctx)); err != nil {
+				d.logger.Errorf("Webhook dispatcher failed: %v", err)
+			}
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context) error {
+	deliveries, err := d.repo.ClaimDueDeliveries(ctx, d.lease, d.batchSize)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, &delivery); err != nil {
+			d.fail(ctx, &delivery, err)
+			continue
+		}
+		if err := d.repo.MarkDelivered(ctx, delivery.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *entities.WebhookDelivery) error {
+	d.logger.Infof("Delivering webhook %d to %s. Attempt: %d", delivery.ID, delivery.URL, delivery.Attempts)
+	body, err := json.Marshal(&webhookBody{
+		DeliveryID: delivery.ID,
+		Event:      delivery.EventType,
+		CreatedAt:  delivery.CreatedAt.Format(time.RFC3339),
+		Data:       delivery.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gophermart-Event", string(delivery.EventType))
+	req.Header.Set("X-Gophermart-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(delivery.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	drainBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) fail(ctx context.Context, delivery *entities.WebhookDelivery, reason error) {
+	status := entities.WebhookDeliveryPending
+	if delivery.Attempts >= d.maxAttempts {
+		status = entities.WebhookDeliveryDead
+		d.logger.Warningf("Webhook %d is dead after %d attempts: %v", delivery.ID, delivery.Attempts, reason)
+	} else {
+		d.logger.Warningf("Webhook %d delivery failed: %v", delivery.ID, reason)
+	}
+	nextAttemptAt := time.Now().Add(exponentialBackoff(delivery.Attempts, d.baseDelay, d.maxDelay))
+	if err := d.repo.MarkFailed(ctx, delivery.ID, reason.Error(), status, nextAttemptAt); err != nil {
+		d.logger.Errorf("Failed to reschedule webhook %d: %v", delivery.ID, err)
+	}
+}
+
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,7 +1,100 @@
 package entities
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-type IAccrualClient interface {
+var ErrCircuitOpen = errors.New("accrual system circuit breaker is open")
+var ErrUnknownAccrualProvider = errors.New("unknown accrual provider")
+
+// Outcomes of a failed accrual request. Only ErrAccrualUnavailable says the accrual system is unhealthy
+var (
+	ErrAccrualUnavailable = errors.New("accrual system unavailable")
+	ErrAccrualThrottled   = errors.New("accrual system rate limit exceeded")
+	ErrAccrualRejected    = errors.New("accrual request rejected")
+)
+
+// IAccrualProvider is a single accrual system
+type IAccrualProvider interface {
 	GetAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error)
 }
+
+// IAccrualClient asks the named provider about the order, an empty name stands for the default one
+type IAccrualClient interface {
+	GetAccrual(ctx context.Context, provider string, orderNumber string) (*AccrualResponse, error)
+}
+
+// IAccrualRouter picks the provider responsible for a new order
+type IAccrualRouter interface {
+	Route(orderNumber string, partner string) string
+}
+
+type ProviderState struct {
+	Name    string           `json:"name"`
+	Kind    string           `json:"kind"`
+	Default bool             `json:"default"`
+	Limiter RateLimiterState `json:"limiter"`
+	Breaker BreakerState     `json:"breaker"`
+}
+
+type IAccrualProviderRegistry interface {
+	IAccrualClient
+	IAccrualRouter
+	States() []ProviderState
+	// Limiter and Breaker resolve the named provider's own, an empty name stands for the default provider
+	Limiter(provider string) (IRateLimiter, error)
+	Breaker(provider string) (ICircuitBreaker, error)
+}
+
+type RateLimiterState struct {
+	RequestsPerMinute           int        `json:"requests_per_minute"`
+	ConfiguredRequestsPerMinute int        `json:"configured_requests_per_minute"`
+	Burst                       int        `json:"burst"`
+	Tokens                      float64    `json:"tokens"`
+	PausedUntil                 *time.Time `json:"paused_until,omitempty"`
+	Throttled                   int64      `json:"throttled"`
+}
+
+type IRateLimiter interface {
+	Wait(ctx context.Context) error
+	Pause(duration time.Duration)
+	SetRate(perMinute int)
+	// Configure replaces the configured rate and burst, dropping any rate adapted to the accrual system
+	Configure(perMinute int, burst int)
+	State() RateLimiterState
+}
+
+type RateLimitRequest struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type BreakerState struct {
+	State    CircuitState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+	Trips    int64        `json:"trips"`
+}
+
+// BreakerTicket is handed out by Allow and identifies a half-open probe. It's zero for calls let through
+// by a closed breaker
+type BreakerTicket int64
+
+type ICircuitBreaker interface {
+	Allow() (BreakerTicket, error)
+	Success(ticket BreakerTicket)
+	Failure(ticket BreakerTicket)
+	// Release returns the slot taken by Allow without counting the call either way
+	Release(ticket BreakerTicket)
+	State() BreakerState
+}
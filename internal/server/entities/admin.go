@@ -0,0 +1,76 @@
+package entities
+
+import "time"
+
+type OrderState struct {
+	Number        string      `json:"number"`
+	UserID        int         `json:"user_id"`
+	Status        OrderStatus `json:"status"`
+	UploadedAt    time.Time   `json:"uploaded_at"`
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt time.Time   `json:"next_attempt_at"`
+	LockedBy      *string     `json:"locked_by,omitempty"`
+	LeaseUntil    *time.Time  `json:"lease_until,omitempty"`
+	StuckAt       *time.Time  `json:"stuck_at,omitempty"`
+	Failures      int         `json:"failures"`
+	LastError     *string     `json:"last_error,omitempty"`
+	DeadAt        *time.Time  `json:"dead_at,omitempty"`
+	ForceRepoll   bool        `json:"force_repoll"`
+	RequeuedAt    *time.Time  `json:"requeued_at,omitempty"`
+	Provider      *string     `json:"provider,omitempty"`
+}
+
+func NewOrderState(order *Order) *OrderState {
+	return &OrderState{
+		Number:        order.Number,
+		UserID:        order.UserID,
+		Status:        order.Status,
+		UploadedAt:    order.UploadedAt,
+		Attempts:      order.Attempts,
+		NextAttemptAt: order.NextAttemptAt,
+		LockedBy:      order.LockedBy,
+		LeaseUntil:    order.LeaseUntil,
+		StuckAt:       order.StuckAt,
+		Failures:      order.Failures,
+		LastError:     order.LastError,
+		DeadAt:        order.DeadAt,
+		ForceRepoll:   order.ForceRepoll,
+		RequeuedAt:    order.RequeuedAt,
+		Provider:      order.Provider,
+	}
+}
+
+type OrderFailure struct {
+	ID       int       `db:"id" json:"-"`
+	OrderID  int       `db:"order_id" json:"-"`
+	Reason   string    `db:"reason" json:"reason"`
+	FailedAt time.Time `db:"failed_at" json:"failed_at"`
+}
+
+type OrderInspection struct {
+	Order    *OrderState         `json:"order"`
+	Failures []OrderFailure      `json:"failures"`
+	History  []OrderStatusChange `json:"history"`
+}
+
+// FailedOrderFilter selects orders with recorded failures, e.g. for bulk requeueing
+type FailedOrderFilter struct {
+	Statuses []OrderStatus `json:"statuses,omitempty"`
+	DeadOnly bool          `json:"dead_only"`
+	Limit    int           `json:"limit,omitempty"`
+}
+
+type RequeueResult struct {
+	Requeued []*OrderState `json:"requeued"`
+}
+
+// PartnerAssignment binds a user to a partner, a null partner unbinds them
+type PartnerAssignment struct {
+	Partner *string `json:"partner"`
+}
+
+type UserState struct {
+	ID      int     `json:"id"`
+	Login   string  `json:"login"`
+	Partner *string `json:"partner,omitempty"`
+}
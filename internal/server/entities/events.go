@@ -0,0 +1,57 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventOrderStatusChanged EventType = "order.status_changed"
+	EventAccrualCreated     EventType = "accrual.created"
+	EventWithdrawalCreated  EventType = "withdrawal.created"
+)
+
+var KnownEventTypes = map[EventType]bool{
+	EventOrderStatusChanged: true,
+	EventAccrualCreated:     true,
+	EventWithdrawalCreated:  true,
+}
+
+type Event struct {
+	ID        int64           `db:"id" json:"id"`
+	UserID    int             `db:"user_id" json:"user_id"`
+	Type      EventType       `db:"event_type" json:"type"`
+	Payload   json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+func NewEvent(userID int, eventType EventType, payload any) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{UserID: userID, Type: eventType, Payload: data, CreatedAt: time.Now()}, nil
+}
+
+type OrderStatusPayload struct {
+	Number  string      `json:"number"`
+	Status  OrderStatus `json:"status"`
+	Accrual float32     `json:"accrual,omitempty"`
+}
+
+type AccrualPayload struct {
+	Number  string  `json:"order"`
+	Accrual float32 `json:"accrual"`
+}
+
+type WithdrawalPayload struct {
+	Number string  `json:"order"`
+	Sum    float32 `json:"sum"`
+}
+
+type IEventBus interface {
+	Publish(event *Event)
+	Subscribe(userID int) (events <-chan Event, unsubscribe func())
+}
@@ -0,0 +1,35 @@
+package entities
+
+import "context"
+
+type CheckStatus string
+
+const (
+	CheckPassed CheckStatus = "ok"
+	CheckFailed CheckStatus = "fail"
+)
+
+type HealthCheck struct {
+	Name       string      `json:"name"`
+	Status     CheckStatus `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+type ReadinessReport struct {
+	Status CheckStatus   `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// LivenessReport always passes while the process serves requests. Its details describe dependencies
+// whose failures don't stop the instance from serving the API
+type LivenessReport struct {
+	Status  CheckStatus   `json:"status"`
+	Details []HealthCheck `json:"details,omitempty"`
+}
+
+// IReadinessProbe tells the orchestrator whether the instance should receive traffic
+type IReadinessProbe interface {
+	Ready(ctx context.Context) *ReadinessReport
+	Details(ctx context.Context) []HealthCheck
+}
@@ -16,9 +16,10 @@ type UserAuthRequest struct {
 }
 
 type User struct {
-	ID           int    `db:"id"`
-	Login        string `db:"login"`
-	PasswordHash []byte `db:"password_hash"`
+	ID           int     `db:"id"`
+	Login        string  `db:"login"`
+	PasswordHash []byte  `db:"password_hash"`
+	Partner      *string `db:"partner"`
 }
 
 type Session struct {
@@ -26,15 +27,29 @@ type Session struct {
 	UserID    int       `db:"user_id"`
 	Token     string    `db:"token"`
 	ExpiresAt time.Time `db:"expires_at"`
+	// Partner of the session's user, it selects the order number validator and the accrual provider
+	Partner *string `db:"partner"`
 }
 
 type Order struct {
-	ID         int       `db:"id"`
-	UserID     int       `db:"user_id"`
-	Number     string    `db:"number" json:"number"`
-	Status     string    `db:"status" json:"status"`
-	UploadedAt time.Time `db:"uploaded_at" json:"uploaded_at"`
-	Accrual    float32   `db:"accrual" json:"accrual"`
+	ID            int         `db:"id"`
+	UserID        int         `db:"user_id"`
+	Number        string      `db:"number" json:"number"`
+	Status        OrderStatus `db:"status" json:"status"`
+	UploadedAt    time.Time   `db:"uploaded_at" json:"uploaded_at"`
+	Accrual       float32     `db:"accrual" json:"accrual"`
+	Validator     string      `db:"validator" json:"-"`
+	LockedBy      *string     `db:"locked_by" json:"-"`
+	LeaseUntil    *time.Time  `db:"lease_until" json:"-"`
+	Attempts      int         `db:"attempts" json:"-"`
+	NextAttemptAt time.Time   `db:"next_attempt_at" json:"-"`
+	StuckAt       *time.Time  `db:"stuck_at" json:"-"`
+	Failures      int         `db:"failures" json:"-"`
+	LastError     *string     `db:"last_error" json:"-"`
+	DeadAt        *time.Time  `db:"dead_at" json:"-"`
+	ForceRepoll   bool        `db:"force_repoll" json:"-"`
+	RequeuedAt    *time.Time  `db:"requeued_at" json:"-"`
+	Provider      *string     `db:"provider" json:"-"`
 }
 
 func (o *Order) MarshalJSON() ([]byte, error) {
@@ -48,6 +63,30 @@ func (o *Order) MarshalJSON() ([]byte, error) {
 	})
 }
 
+type OrderStatusChange struct {
+	ID              int              `db:"id" json:"-"`
+	OrderID         int              `db:"order_id" json:"-"`
+	Status          OrderStatus      `db:"status" json:"status"`
+	AccrualResponse *json.RawMessage `db:"accrual_response" json:"accrual_response,omitempty"`
+	ChangedAt       time.Time        `db:"changed_at" json:"changed_at"`
+}
+
+func (c *OrderStatusChange) MarshalJSON() ([]byte, error) {
+	type Alias OrderStatusChange
+	return json.Marshal(&struct {
+		ChangedAt string `json:"changed_at"`
+		*Alias
+	}{
+		ChangedAt: c.ChangedAt.Format(time.RFC3339),
+		Alias:     (*Alias)(c),
+	})
+}
+
+type OrderDetails struct {
+	Order   *Order              `json:"order"`
+	History []OrderStatusChange `json:"history"`
+}
+
 type Accrual struct {
 	ID          int          `db:"id"`
 	UserID      int          `db:"user_id"`
@@ -85,9 +124,10 @@ func (a *Accrual) UnmarshalJSON(data []byte) error {
 }
 
 type AccrualResponse struct {
-	OrderNumber string  `json:"order"`
-	Status      string  `json:"status"`
-	Amount      float32 `json:"accrual"`
+	OrderNumber string      `json:"order"`
+	Status      OrderStatus `json:"status"`
+	Amount      float32     `json:"accrual"`
+	Raw         []byte      `json:"-"`
 }
 
 type Balance struct {
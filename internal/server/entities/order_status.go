@@ -0,0 +1,50 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownOrderStatus      = errors.New("unknown order status")
+	ErrIllegalStatusTransition = errors.New("illegal order status transition")
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusNew        OrderStatus = "NEW"
+	OrderStatusRegistered OrderStatus = "REGISTERED"
+	OrderStatusProcessing OrderStatus = "PROCESSING"
+	OrderStatusInvalid    OrderStatus = "INVALID"
+	OrderStatusProcessed  OrderStatus = "PROCESSED"
+)
+
+var orderStatusTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusNew:        {OrderStatusRegistered, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusRegistered: {OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusProcessing: {OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusInvalid:    {},
+	OrderStatusProcessed:  {},
+}
+
+func ParseOrderStatus(value string) (OrderStatus, error) {
+	status := OrderStatus(value)
+	if _, ok := orderStatusTransitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownOrderStatus, value)
+	}
+	return status, nil
+}
+
+func (s OrderStatus) IsFinal() bool {
+	return s == OrderStatusInvalid || s == OrderStatusProcessed
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
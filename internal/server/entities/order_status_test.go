@@ -0,0 +1,93 @@
+package entities
+
+import (
+	"errors"
+	"testing"
+)
+
+var allStatuses = []OrderStatus{
+	OrderStatusNew, OrderStatusRegistered, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed,
+}
+
+func TestCanTransitionTo(t *testing.T) {
+	allowed := map[OrderStatus]map[OrderStatus]bool{
+		OrderStatusNew: {
+			OrderStatusRegistered: true, OrderStatusProcessing: true, OrderStatusInvalid: true, OrderStatusProcessed: true,
+		},
+		OrderStatusRegistered: {OrderStatusProcessing: true, OrderStatusInvalid: true, OrderStatusProcessed: true},
+		OrderStatusProcessing: {OrderStatusInvalid: true, OrderStatusProcessed: true},
+		OrderStatusInvalid:    {},
+		OrderStatusProcessed:  {},
+	}
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				if got := from.CanTransitionTo(to); got != allowed[from][to] {
+					t.Errorf("CanTransitionTo() = %v, want %v", got, allowed[from][to])
+				}
+			})
+		}
+	}
+}
+
+func TestCanTransitionToUnknown(t *testing.T) {
+	for _, status := range allStatuses {
+		if status.CanTransitionTo("DONE") {
+			t.Errorf("%s -> DONE is allowed", status)
+		}
+		if OrderStatus("DONE").CanTransitionTo(status) {
+			t.Errorf("DONE -> %s is allowed", status)
+		}
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	tests := []struct {
+		status OrderStatus
+		want   bool
+	}{
+		{OrderStatusNew, false},
+		{OrderStatusRegistered, false},
+		{OrderStatusProcessing, false},
+		{OrderStatusInvalid, true},
+		{OrderStatusProcessed, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			if got := tt.status.IsFinal(); got != tt.want {
+				t.Errorf("IsFinal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderStatus(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    OrderStatus
+		wantErr bool
+	}{
+		{"NEW", OrderStatusNew, false},
+		{"REGISTERED", OrderStatusRegistered, false},
+		{"PROCESSING", OrderStatusProcessing, false},
+		{"INVALID", OrderStatusInvalid, false},
+		{"PROCESSED", OrderStatusProcessed, false},
+		{"processed", "", true},
+		{"", "", true},
+		{"DONE", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseOrderStatus(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownOrderStatus) {
+					t.Errorf("ParseOrderStatus() error = %v, want ErrUnknownOrderStatus", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseOrderStatus() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
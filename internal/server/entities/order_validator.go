@@ -0,0 +1,14 @@
+package entities
+
+import "errors"
+
+var ErrUnknownOrderValidator = errors.New("unknown order number validator")
+
+type IOrderValidator interface {
+	Name() string
+	Validate(number string) error
+}
+
+type IOrderValidatorRegistry interface {
+	Resolve(partner string) IOrderValidator
+}
@@ -0,0 +1,42 @@
+package entities
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Cursor struct {
+	At time.Time
+	ID int
+}
+
+func (c *Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.At.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var nanos int64
+	var id int
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &nanos, &id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{At: time.Unix(0, nanos), ID: id}, nil
+}
+
+type ListFilter struct {
+	Limit    int
+	After    *Cursor
+	Statuses []OrderStatus
+	From     *time.Time
+	To       *time.Time
+	Desc     bool
+}
@@ -0,0 +1,102 @@
+package entities
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrReconciliationRunning = errors.New("a reconciliation is already running")
+
+type DiscrepancyKind string
+
+const (
+	DiscrepancyMissingAccrual DiscrepancyKind = "missing_accrual"
+	DiscrepancyAmountMismatch DiscrepancyKind = "amount_mismatch"
+	DiscrepancyStatusMismatch DiscrepancyKind = "status_mismatch"
+	DiscrepancyUnknownRemote  DiscrepancyKind = "unknown_remote"
+	DiscrepancyRemoteError    DiscrepancyKind = "remote_error"
+)
+
+// ReconciledOrder is an order together with the accrual stored for it, if any
+type ReconciledOrder struct {
+	Number     string      `db:"number"`
+	UserID     int         `db:"user_id"`
+	Provider   string      `db:"provider"`
+	Status     OrderStatus `db:"status"`
+	Accrual    *float32    `db:"accrual"`
+	UploadedAt time.Time   `db:"uploaded_at"`
+}
+
+type Discrepancy struct {
+	OrderNumber  string          `json:"order"`
+	UserID       int             `json:"user_id"`
+	Kind         DiscrepancyKind `json:"kind"`
+	LocalStatus  OrderStatus     `json:"local_status"`
+	RemoteStatus OrderStatus     `json:"remote_status,omitempty"`
+	LocalAmount  *float32        `json:"local_amount,omitempty"`
+	RemoteAmount *float32        `json:"remote_amount,omitempty"`
+	Adjusted     bool            `json:"adjusted"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type ReconciliationRequest struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Adjust bool      `json:"adjust"`
+}
+
+// ReconciliationReport is filled in while the orders are checked. When the order cap is hit the report
+// is truncated and another reconciliation can continue from ResumeFrom
+type ReconciliationReport struct {
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	StartedAt     time.Time     `json:"started_at"`
+	FinishedAt    *time.Time    `json:"finished_at,omitempty"`
+	Total         int           `json:"total"`
+	Checked       int           `json:"checked"`
+	Adjusted      int           `json:"adjusted"`
+	Truncated     bool          `json:"truncated"`
+	ResumeFrom    *time.Time    `json:"resume_from,omitempty"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+type ReconciliationState string
+
+const (
+	ReconciliationQueued   ReconciliationState = "queued"
+	ReconciliationRunning  ReconciliationState = "running"
+	ReconciliationFinished ReconciliationState = "finished"
+	ReconciliationFailed   ReconciliationState = "failed"
+)
+
+// ReconciliationJob is a reconciliation running in the background
+type ReconciliationJob struct {
+	ID      int64                 `json:"id"`
+	State   ReconciliationState   `json:"state"`
+	Request ReconciliationRequest `json:"request"`
+	Error   string                `json:"error,omitempty"`
+	Report  *ReconciliationReport `json:"report,omitempty"`
+}
+
+func (j *ReconciliationJob) Done() bool {
+	return j.State == ReconciliationFinished || j.State == ReconciliationFailed
+}
+
+// ForceRepollReason marks adjustments made by a forced re-poll of a final order
+const ForceRepollReason = "force_repoll"
+
+type AccrualAdjustment struct {
+	UserID      int         `db:"user_id"`
+	OrderNumber string      `db:"order_number"`
+	OldStatus   OrderStatus `db:"old_status"`
+	NewStatus   OrderStatus `db:"new_status"`
+	OldAmount   *float32    `db:"old_amount"`
+	NewAmount   float32     `db:"new_amount"`
+	Reason      string      `db:"reason"`
+}
+
+type IReconciler interface {
+	Start(req *ReconciliationRequest) (*ReconciliationJob, error)
+	Job(id int64) *ReconciliationJob
+	Jobs() []ReconciliationJob
+}
@@ -3,6 +3,7 @@ package entities
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -13,6 +14,7 @@ type Tx interface {
 	Commit() error
 	Rollback() error
 	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
 	ExecContext(ctx context.Context, query string, args ...any) error
 }
 
@@ -20,6 +22,7 @@ type Storage interface {
 	Tx(ctx context.Context) (Tx, error)
 	SelectContext(ctx context.Context, dest any, query string, args ...any) error
 	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	ExecContext(ctx context.Context, query string, args ...any) error
 }
 
 type UserRepo interface {
@@ -27,19 +30,65 @@ type UserRepo interface {
 	FindUser(ctx context.Context, request *UserAuthRequest) (*User, error)
 	CreateSession(ctx context.Context, tx Tx, user *User, token string) (*Session, error)
 	FindSession(ctx context.Context, token string) (*Session, error)
+	SetPartner(ctx context.Context, login string, partner *string) (*User, error)
 }
 
 type OrderRepo interface {
-	CreateOrder(ctx context.Context, userID int, number string) (*Order, bool, error)
+	CreateOrder(
+		ctx context.Context, tx Tx, userID int, number string, validator string, provider string,
+	) (*Order, bool, error)
 	FindOrder(ctx context.Context, number string) (*Order, error)
-	FindUserOrders(ctx context.Context, userID int) ([]Order, error)
-	FetchUnprocessedOrders(ctx context.Context, limit int) ([]Order, error)
-	UpdateOrderStatus(ctx context.Context, tx Tx, number string, status string) error
+	FindUserOrders(ctx context.Context, userID int, filter *ListFilter) ([]Order, *Cursor, error)
+	FindOrderHistory(ctx context.Context, orderID int) ([]OrderStatusChange, error)
+	FetchUnprocessedOrders(
+		ctx context.Context, owner string, lease time.Duration, limit int, skipProviders []string,
+	) ([]Order, error)
+	RenewLease(ctx context.Context, number string, owner string, lease time.Duration) (bool, error)
+	ReleaseOrder(ctx context.Context, number string, owner string) error
+	NotifyOrderCreated(ctx context.Context, tx Tx, channel string, number string) error
+	ScheduleNextAttempt(ctx context.Context, number string, nextAttemptAt time.Time) error
+	PostponeAttempt(ctx context.Context, number string, nextAttemptAt time.Time) error
+	MarkStuckOrders(ctx context.Context, uploadedBefore time.Time) ([]Order, error)
+	FindStuckOrders(ctx context.Context, limit int) ([]Order, error)
+	UpdateOrderStatus(ctx context.Context, tx Tx, number string, status OrderStatus, cause []byte) (bool, error)
+	RecordFailure(ctx context.Context, number string, reason string, maxFailures int) (*Order, error)
+	FinishRepoll(ctx context.Context, tx Tx, number string) error
+	FindFailedOrders(ctx context.Context, filter *FailedOrderFilter) ([]Order, error)
+	FindOrderFailures(ctx context.Context, orderID int) ([]OrderFailure, error)
+	RequeueOrder(ctx context.Context, number string, force bool) (*Order, error)
+	RequeueFailedOrders(ctx context.Context, filter *FailedOrderFilter) ([]Order, error)
+	FindOrdersForReconciliation(ctx context.Context, from time.Time, to time.Time, limit int) ([]ReconciledOrder, error)
+	CountOrdersByStatus(ctx context.Context) (map[OrderStatus]int, error)
 }
 
 type AccrualRepo interface {
 	GetBalance(ctx context.Context, userID int) (*Balance, error)
-	CreateWithdrawal(ctx context.Context, withdrawal *Accrual) (*Accrual, error)
-	FindUserWithdrawals(ctx context.Context, userID int) ([]Accrual, error)
+	CreateWithdrawal(ctx context.Context, tx Tx, withdrawal *Accrual) (*Accrual, error)
+	FindUserWithdrawals(ctx context.Context, userID int, filter *ListFilter) ([]Accrual, *Cursor, error)
 	CreateAccrual(ctx context.Context, tx Tx, userID int, accrual *AccrualResponse) error
+	FindAccrualAmount(ctx context.Context, tx Tx, userID int, orderNumber string) (*float32, error)
+	RecordAdjustment(ctx context.Context, tx Tx, adjustment *AccrualAdjustment) error
+}
+
+type WebhookRepo interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscription) (*WebhookSubscription, error)
+	FindUserSubscriptions(ctx context.Context, userID int) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, userID int, id int) error
+	EnqueueEvent(ctx context.Context, tx Tx, event *Event) error
+	FindUserDeliveries(
+		ctx context.Context, userID int, status WebhookDeliveryStatus, limit int,
+	) ([]WebhookDelivery, error)
+	Redeliver(ctx context.Context, userID int, id int64) error
+	ClaimDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, reason string, status WebhookDeliveryStatus, nextAttemptAt time.Time) error
+}
+
+type EventRepo interface {
+	AppendEvent(ctx context.Context, tx Tx, event *Event) error
+	FindUserEventsAfter(ctx context.Context, userID int, afterID int64, limit int) ([]Event, error)
+	LockRelay(ctx context.Context, tx Tx) (bool, error)
+	FetchUnpublishedEvents(ctx context.Context, tx Tx, limit int) ([]Event, error)
+	NotifyEvent(ctx context.Context, tx Tx, channel string, event *Event) error
+	MarkEventsPublished(ctx context.Context, tx Tx, ids []int64) error
 }
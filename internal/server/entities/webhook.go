@@ -0,0 +1,66 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookDeliveryDead      WebhookDeliveryStatus = "DEAD"
+)
+
+type EventTypes []EventType
+
+func (t EventTypes) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+func (t *EventTypes) Scan(src any) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, t)
+	case string:
+		return json.Unmarshal([]byte(data), t)
+	default:
+		return fmt.Errorf("cannot scan %T into EventTypes", src)
+	}
+}
+
+type WebhookSubscription struct {
+	ID         int        `db:"id" json:"id"`
+	UserID     int        `db:"user_id" json:"-"`
+	URL        string     `db:"url" json:"url"`
+	Secret     string     `db:"secret" json:"secret,omitempty"`
+	EventTypes EventTypes `db:"event_types" json:"events"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+type WebhookSubscriptionRequest struct {
+	URL        string     `json:"url"`
+	Secret     string     `json:"secret"`
+	EventTypes EventTypes `json:"events"`
+}
+
+type WebhookDelivery struct {
+	ID             int64                 `db:"id" json:"id"`
+	SubscriptionID int                   `db:"subscription_id" json:"subscription_id"`
+	EventType      EventType             `db:"event_type" json:"event"`
+	Payload        json.RawMessage       `db:"payload" json:"payload"`
+	Status         WebhookDeliveryStatus `db:"status" json:"status"`
+	Attempts       int                   `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time             `db:"next_attempt_at" json:"next_attempt_at"`
+	LastError      *string               `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt      time.Time             `db:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time            `db:"delivered_at" json:"delivered_at,omitempty"`
+	URL            string                `db:"url" json:"-"`
+	Secret         string                `db:"secret" json:"-"`
+}
@@ -1,6 +1,63 @@
 package entities
 
+import (
+	"errors"
+	"time"
+)
+
 type Job struct {
 	UserID      int
 	OrderNumber string
+	Provider    string
+	Attempts    int
+	// Trace carries the supplier's trace context, so the collector span can link back to it
+	Trace map[string]string
+}
+
+type WorkerState string
+
+const (
+	WorkerRunning    WorkerState = "running"
+	WorkerRestarting WorkerState = "restarting"
+	WorkerStopped    WorkerState = "stopped"
+)
+
+type WorkerStatus struct {
+	Name      string      `json:"name"`
+	State     WorkerState `json:"state"`
+	StartedAt time.Time   `json:"started_at"`
+	Restarts  int         `json:"restarts"`
+	LastPanic string      `json:"last_panic,omitempty"`
+}
+
+// IOrderScheduler triggers processing of new orders without waiting for the next sweep
+type IOrderScheduler interface {
+	Wake()
+}
+
+type IWorkerSupervisor interface {
+	Statuses() []WorkerStatus
+}
+
+var ErrInvalidPoolSize = errors.New("pool bounds must satisfy 1 <= min <= max")
+
+type PoolState struct {
+	Size          int     `json:"size"`
+	Min           int     `json:"min"`
+	Max           int     `json:"max"`
+	QueueDepth    int     `json:"queue_depth"`
+	QueueCapacity int     `json:"queue_capacity"`
+	Pending       int     `json:"pending"`
+	Busy          int     `json:"busy"`
+	AvgLatencyMs  float64 `json:"avg_latency_ms"`
+}
+
+type PoolResizeRequest struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+type ICollectorPool interface {
+	State() PoolState
+	Resize(min, max int) error
 }
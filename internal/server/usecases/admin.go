@@ -0,0 +1,366 @@
+package usecases
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type AdminController struct {
+	logger     logging.ILogger
+	userRepo   entities.UserRepo
+	orderRepo  entities.OrderRepo
+	providers  entities.IAccrualProviderRegistry
+	workers    entities.IWorkerSupervisor
+	pool       entities.ICollectorPool
+	scheduler  entities.IOrderScheduler
+	reconciler entities.IReconciler
+}
+
+func NewAdminController(
+	logger logging.ILogger,
+	userRepo entities.UserRepo,
+	orderRepo entities.OrderRepo,
+	providers entities.IAccrualProviderRegistry,
+	workers entities.IWorkerSupervisor,
+	pool entities.ICollectorPool,
+	scheduler entities.IOrderScheduler,
+	reconciler entities.IReconciler,
+) *AdminController {
+	return &AdminController{
+		logger:     logger,
+		userRepo:   userRepo,
+		orderRepo:  orderRepo,
+		providers:  providers,
+		workers:    workers,
+		pool:       pool,
+		scheduler:  scheduler,
+		reconciler: reconciler,
+	}
+}
+
+func (c *AdminController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/orders/stuck", c.getStuckOrders)
+	r.Get("/orders/failed", c.getFailedOrders)
+	r.Post("/orders/failed/requeue", c.requeueFailedOrders)
+	r.Get("/orders/{number}", c.inspectOrder)
+	r.Post("/orders/{number}/requeue", c.requeueOrder)
+	r.Post("/reconciliations", c.reconcile)
+	r.Get("/reconciliations", c.getReconciliations)
+	r.Get("/reconciliations/{id}", c.getReconciliation)
+	r.Get("/accrual/limiter", c.getLimiterState)
+	r.Put("/accrual/limiter", c.configureLimiter)
+	r.Get("/accrual/breaker", c.getBreakerState)
+	r.Get("/accrual/providers", c.getProviders)
+	r.Get("/workers", c.getWorkers)
+	r.Get("/workers/pool", c.getPoolState)
+	r.Put("/workers/pool", c.resizePool)
+	r.Put("/users/{login}/partner", c.assignPartner)
+	return r
+}
+
+func (c *AdminController) getStuckOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := c.orderRepo.FindStuckOrders(r.Context(), config.MaxPageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find stuck orders"))
+		return
+	}
+	writeOrderStates(w, orders)
+}
+
+func (c *AdminController) getFailedOrders(w http.ResponseWriter, r *http.Request) {
+	filter := validateFailedOrderFilter(w, r)
+	if filter == nil {
+		return
+	}
+	orders, err := c.orderRepo.FindFailedOrders(r.Context(), filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find failed orders"))
+		return
+	}
+	writeOrderStates(w, orders)
+}
+
+func (c *AdminController) requeueFailedOrders(w http.ResponseWriter, r *http.Request) {
+	filter := validateRequeueRequest(w, r)
+	if filter == nil {
+		return
+	}
+	orders, err := c.orderRepo.RequeueFailedOrders(r.Context(), filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to requeue failed orders"))
+		return
+	}
+	c.scheduler.Wake()
+	result := entities.RequeueResult{Requeued: make([]*entities.OrderState, 0, len(orders))}
+	for i := range orders {
+		result.Requeued = append(result.Requeued, entities.NewOrderState(&orders[i]))
+	}
+	writeJSON(w, result)
+}
+
+func (c *AdminController) inspectOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := c.orderRepo.FindOrder(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find the order"))
+		return
+	}
+	if order == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Order not found"))
+		return
+	}
+	failures, err := c.orderRepo.FindOrderFailures(r.Context(), order.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find order failures"))
+		return
+	}
+	history, err := c.orderRepo.FindOrderHistory(r.Context(), order.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find order history"))
+		return
+	}
+	writeJSON(w, &entities.OrderInspection{
+		Order:    entities.NewOrderState(order),
+		Failures: failures,
+		History:  history,
+	})
+}
+
+// requeueOrder puts the order back into processing. Final orders are only re-polled with force=true
+func (c *AdminController) requeueOrder(w http.ResponseWriter, r *http.Request) {
+	force, err := strconv.ParseBool(r.URL.Query().Get("force"))
+	if err != nil && r.URL.Query().Get("force") != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid force flag"))
+		return
+	}
+	number := chi.URLParam(r, "number")
+	order, err := c.orderRepo.FindOrder(r.Context(), number)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find the order"))
+		return
+	}
+	if order == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Order not found"))
+		return
+	}
+	if order.Status == entities.OrderStatusInvalid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Invalid orders can't be requeued"))
+		return
+	}
+	if order.Status.IsFinal() && !force {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Order is already processed, use force=true to re-poll it"))
+		return
+	}
+	if order, err = c.orderRepo.RequeueOrder(r.Context(), number, force); err != nil || order == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to requeue the order"))
+		return
+	}
+	c.scheduler.Wake()
+	writeJSON(w, entities.NewOrderState(order))
+}
+
+func (c *AdminController) reconcile(w http.ResponseWriter, r *http.Request) {
+	req := validateReconciliationRequest(w, r)
+	if req == nil {
+		return
+	}
+	job, err := c.reconciler.Start(req)
+	if errors.Is(err, entities.ErrReconciliationRunning) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Another reconciliation is in progress"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to start a reconciliation"))
+		return
+	}
+	response, err := json.Marshal(job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", r.URL.Path, job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(response)
+}
+
+func (c *AdminController) getReconciliations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.reconciler.Jobs())
+}
+
+func (c *AdminController) getReconciliation(w http.ResponseWriter, r *http.Request) {
+	id := validateReconciliationID(w, r)
+	if id == nil {
+		return
+	}
+	job := c.reconciler.Job(*id)
+	if job == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Reconciliation not found"))
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		if !job.Done() || job.Report == nil {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Reconciliation is not finished yet"))
+			return
+		}
+		writeDiscrepanciesCSV(w, job.Report.Discrepancies)
+		return
+	}
+	writeJSON(w, job)
+}
+
+// The limiter and breaker handlers act on the provider from the query, the default one without it
+
+func (c *AdminController) getLimiterState(w http.ResponseWriter, r *http.Request) {
+	limiter, err := c.providers.Limiter(r.URL.Query().Get("provider"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, limiter.State())
+}
+
+func (c *AdminController) configureLimiter(w http.ResponseWriter, r *http.Request) {
+	req := validateRateLimitRequest(w, r)
+	if req == nil {
+		return
+	}
+	limiter, err := c.providers.Limiter(r.URL.Query().Get("provider"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	limiter.Configure(req.RequestsPerMinute, req.Burst)
+	writeJSON(w, limiter.State())
+}
+
+func (c *AdminController) getBreakerState(w http.ResponseWriter, r *http.Request) {
+	breaker, err := c.providers.Breaker(r.URL.Query().Get("provider"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, breaker.State())
+}
+
+func (c *AdminController) getProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.providers.States())
+}
+
+func (c *AdminController) getWorkers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.workers.Statuses())
+}
+
+func (c *AdminController) getPoolState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.pool.State())
+}
+
+func (c *AdminController) resizePool(w http.ResponseWriter, r *http.Request) {
+	req := validatePoolResizeRequest(w, r)
+	if req == nil {
+		return
+	}
+	if err := c.pool.Resize(req.Min, req.Max); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	writeJSON(w, c.pool.State())
+}
+
+func (c *AdminController) assignPartner(w http.ResponseWriter, r *http.Request) {
+	assignment := validatePartnerAssignment(w, r)
+	if assignment == nil {
+		return
+	}
+	user, err := c.userRepo.SetPartner(r.Context(), chi.URLParam(r, "login"), assignment.Partner)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to assign the user to a partner"))
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("User not found"))
+		return
+	}
+	writeJSON(w, &entities.UserState{ID: user.ID, Login: user.Login, Partner: user.Partner})
+}
+
+func writeJSON(w http.ResponseWriter, result any) {
+	response, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func writeOrderStates(w http.ResponseWriter, orders []entities.Order) {
+	states := make([]*entities.OrderState, 0, len(orders))
+	for i := range orders {
+		states = append(states, entities.NewOrderState(&orders[i]))
+	}
+	writeJSON(w, states)
+}
+
+func writeDiscrepanciesCSV(w http.ResponseWriter, discrepancies []entities.Discrepancy) {
+	w.Header().Set("Content-Type", "text/csv")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"order", "user_id", "kind", "local_status", "remote_status", "local_amount", "remote_amount", "adjusted", "error",
+	})
+	for _, d := range discrepancies {
+		writer.Write([]string{
+			d.OrderNumber,
+			strconv.Itoa(d.UserID),
+			string(d.Kind),
+			string(d.LocalStatus),
+			string(d.RemoteStatus),
+			formatAmount(d.LocalAmount),
+			formatAmount(d.RemoteAmount),
+			strconv.FormatBool(d.Adjusted),
+			d.Error,
+		})
+	}
+	writer.Flush()
+}
+
+func formatAmount(amount *float32) string {
+	if amount == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*amount), 'f', 2, 32)
+}
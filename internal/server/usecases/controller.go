@@ -12,7 +12,13 @@ type BaseController struct {
 	userRepo    entities.UserRepo
 	orderRepo   entities.OrderRepo
 	accrualRepo entities.AccrualRepo
+	webhookRepo entities.WebhookRepo
+	eventRepo   entities.EventRepo
 	crypto      entities.ICryptoProvider
+	validators  entities.IOrderValidatorRegistry
+	events      entities.IEventBus
+	scheduler   entities.IOrderScheduler
+	router      entities.IAccrualRouter
 }
 
 func NewBaseController(
@@ -21,7 +27,13 @@ func NewBaseController(
 	userRepo entities.UserRepo,
 	orderRepo entities.OrderRepo,
 	accrualRepo entities.AccrualRepo,
+	webhookRepo entities.WebhookRepo,
+	eventRepo entities.EventRepo,
 	crypto entities.ICryptoProvider,
+	validators entities.IOrderValidatorRegistry,
+	events entities.IEventBus,
+	scheduler entities.IOrderScheduler,
+	router entities.IAccrualRouter,
 ) *BaseController {
 	return &BaseController{
 		logger:      logger,
@@ -29,7 +41,13 @@ func NewBaseController(
 		userRepo:    userRepo,
 		orderRepo:   orderRepo,
 		accrualRepo: accrualRepo,
+		webhookRepo: webhookRepo,
+		eventRepo:   eventRepo,
 		crypto:      crypto,
+		validators:  validators,
+		events:      events,
+		scheduler:   scheduler,
+		router:      router,
 	}
 }
 
@@ -39,8 +57,15 @@ func (c *BaseController) Route() *chi.Mux {
 	r.Post("/user/login", c.signIn)
 	r.Post("/user/orders", c.createOrder)
 	r.Get("/user/orders", c.getOrders)
+	r.Get("/user/orders/{number}", c.getOrder)
 	r.Get("/user/balance", c.getBalance)
 	r.Post("/user/balance/withdraw", c.withdraw)
 	r.Get("/user/withdrawals", c.getWithdrawals)
+	r.Get("/user/events", c.streamEvents)
+	r.Post("/user/webhooks", c.createWebhook)
+	r.Get("/user/webhooks", c.getWebhooks)
+	r.Delete("/user/webhooks/{id}", c.deleteWebhook)
+	r.Get("/user/webhooks/deliveries", c.getWebhookDeliveries)
+	r.Post("/user/webhooks/deliveries/{id}/redeliver", c.redeliverWebhook)
 	return r
 }
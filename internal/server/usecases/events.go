@@ -0,0 +1,95 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+func (c *BaseController) streamEvents(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming is not supported"))
+		return
+	}
+	var lastEventID int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		parsed, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid Last-Event-ID"))
+			return
+		}
+		lastEventID = parsed
+	}
+	events, unsubscribe := c.events.Subscribe(*userID)
+	defer unsubscribe()
+	var backlog []entities.Event
+	if lastEventID > 0 {
+		var err error
+		backlog, err = c.eventRepo.FindUserEventsAfter(r.Context(), *userID, lastEventID, config.EventsReplayLimit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to replay events"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// the backlog is replayed page by page until it catches up, live events up to it are skipped below
+	for {
+		for _, event := range backlog {
+			writeEvent(w, &event)
+			lastEventID = event.ID
+		}
+		flusher.Flush()
+		if len(backlog) < config.EventsReplayLimit {
+			break
+		}
+		var err error
+		backlog, err = c.eventRepo.FindUserEventsAfter(r.Context(), *userID, lastEventID, config.EventsReplayLimit)
+		if err != nil {
+			// the client reconnects with the last replayed event id
+			logging.FromContext(r.Context(), c.logger).Errorf("Failed to replay events: %v", err)
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(config.EventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.ID <= lastEventID {
+				continue
+			}
+			writeEvent(w, &event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *entities.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Payload)
+}
@@ -3,10 +3,13 @@ package usecases
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/infra/metrics"
 	"github.com/matthiasBT/gophermart/internal/server/entities"
 )
 
@@ -94,17 +97,28 @@ func (c *BaseController) createOrder(w http.ResponseWriter, r *http.Request) {
 	if userID == nil {
 		return
 	}
-	number := validateOrderNumber(w, r)
+	partner := getPartner(r)
+	validator := c.validators.Resolve(partner)
+	number := validateOrderNumber(w, r, validator)
 	if number == nil {
 		return
 	}
-	order, existed, err := c.orderRepo.CreateOrder(r.Context(), *userID, *number)
+	tx, err := c.stor.Tx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to create an order"))
+		return
+	}
+	provider := c.router.Route(*number, partner)
+	order, existed, err := c.orderRepo.CreateOrder(r.Context(), tx, *userID, *number, validator.Name(), provider)
 	if err != nil {
+		tx.Rollback()
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to create an order"))
 		return
 	}
 	if existed {
+		tx.Rollback()
 		if order.UserID == *userID {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("already created"))
@@ -114,6 +128,31 @@ func (c *BaseController) createOrder(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	event, err := entities.NewEvent(*userID, entities.EventOrderStatusChanged, &entities.OrderStatusPayload{
+		Number: order.Number,
+		Status: order.Status,
+	})
+	if err == nil {
+		err = c.eventRepo.AppendEvent(r.Context(), tx, event)
+	}
+	if err == nil {
+		err = c.webhookRepo.EnqueueEvent(r.Context(), tx, event)
+	}
+	if err == nil {
+		err = c.orderRepo.NotifyOrderCreated(r.Context(), tx, config.OrdersChannel, order.Number)
+	}
+	if err != nil {
+		tx.Rollback()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to create an order"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to create an order"))
+		return
+	}
+	c.scheduler.Wake()
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -122,7 +161,11 @@ func (c *BaseController) getOrders(w http.ResponseWriter, r *http.Request) {
 	if userID == nil {
 		return
 	}
-	orders, err := c.orderRepo.FindUserOrders(r.Context(), *userID)
+	filter := validateListFilter(w, r, true)
+	if filter == nil {
+		return
+	}
+	orders, next, err := c.orderRepo.FindUserOrders(r.Context(), *userID, filter)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to find user's orders"))
@@ -132,7 +175,32 @@ func (c *BaseController) getOrders(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	response, err := json.Marshal(orders)
+	writePage(w, r, orders, next)
+}
+
+func (c *BaseController) getOrder(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	order, err := c.orderRepo.FindOrder(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find the order"))
+		return
+	}
+	if order == nil || order.UserID != *userID {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Order not found"))
+		return
+	}
+	history, err := c.orderRepo.FindOrderHistory(r.Context(), order.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find the order status history"))
+		return
+	}
+	response, err := json.Marshal(&entities.OrderDetails{Order: order, History: history})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to marshal the result"))
@@ -168,7 +236,7 @@ func (c *BaseController) withdraw(w http.ResponseWriter, r *http.Request) {
 	if userID == nil {
 		return
 	}
-	withdrawal := validateWithdrawal(w, r, *userID)
+	withdrawal := validateWithdrawal(w, r, *userID, c.validators.Resolve(getPartner(r)))
 	if withdrawal == nil {
 		return
 	}
@@ -183,11 +251,45 @@ func (c *BaseController) withdraw(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("insufficient funds"))
 		return
 	}
-	if _, err := c.accrualRepo.CreateWithdrawal(r.Context(), withdrawal); err != nil {
+	event, err := entities.NewEvent(*userID, entities.EventWithdrawalCreated, &entities.WithdrawalPayload{
+		Number: withdrawal.OrderNumber,
+		Sum:    withdrawal.Amount,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed to build withdrawal event"))
+		return
+	}
+	tx, err := c.stor.Tx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed to create withdrawal"))
+		return
+	}
+	if _, err := c.accrualRepo.CreateWithdrawal(r.Context(), tx, withdrawal); err != nil {
+		tx.Rollback()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed to create withdrawal"))
+		return
+	}
+	if err := c.eventRepo.AppendEvent(r.Context(), tx, event); err != nil {
+		tx.Rollback()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed to create withdrawal"))
+		return
+	}
+	if err := c.webhookRepo.EnqueueEvent(r.Context(), tx, event); err != nil {
+		tx.Rollback()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed to create withdrawal"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("failed to create withdrawal"))
 		return
 	}
+	metrics.PointsWithdrawn.Add(float64(withdrawal.Amount))
 }
 
 func (c *BaseController) getWithdrawals(w http.ResponseWriter, r *http.Request) {
@@ -195,7 +297,11 @@ func (c *BaseController) getWithdrawals(w http.ResponseWriter, r *http.Request)
 	if userID == nil {
 		return
 	}
-	withdrawals, err := c.accrualRepo.FindUserWithdrawals(r.Context(), *userID)
+	filter := validateListFilter(w, r, false)
+	if filter == nil {
+		return
+	}
+	withdrawals, next, err := c.accrualRepo.FindUserWithdrawals(r.Context(), *userID, filter)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to find user's withdrawals"))
@@ -205,14 +311,7 @@ func (c *BaseController) getWithdrawals(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	response, err := json.Marshal(withdrawals)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to marshal the result"))
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(response)
+	writePage(w, r, withdrawals, next)
 }
 
 func authorize(w http.ResponseWriter, session *entities.Session) {
@@ -226,6 +325,26 @@ func authorize(w http.ResponseWriter, session *entities.Session) {
 	})
 }
 
+func writePage(w http.ResponseWriter, r *http.Request, items any, next *entities.Cursor) {
+	response, err := json.Marshal(items)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	if next != nil {
+		encoded := next.Encode()
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("cursor", encoded)
+		nextURL.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.RequestURI()))
+		w.Header().Set("X-Next-Cursor", encoded)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
 func getUserID(w http.ResponseWriter, r *http.Request) *int {
 	userID := r.Context().Value(entities.ContextKey{Key: "user_id"})
 	if userID == nil {
@@ -237,6 +356,8 @@ func getUserID(w http.ResponseWriter, r *http.Request) *int {
 	return &res
 }
 
-func isFinalStatus(status string) bool {
-	return status == "INVALID" || status == "PROCESSED"
+// getPartner returns the partner the authenticated user belongs to, if any
+func getPartner(r *http.Request) string {
+	partner, _ := r.Context().Value(entities.ContextKey{Key: "partner"}).(string)
+	return partner
 }
@@ -0,0 +1,47 @@
+package usecases
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/logging"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+type HealthController struct {
+	logger logging.ILogger
+	probe  entities.IReadinessProbe
+}
+
+func NewHealthController(logger logging.ILogger, probe entities.IReadinessProbe) *HealthController {
+	return &HealthController{logger: logger, probe: probe}
+}
+
+func (c *HealthController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/healthz", c.live)
+	r.Get("/readyz", c.ready)
+	return r
+}
+
+// live only tells that the process is able to serve requests, the details are informational
+func (c *HealthController) live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &entities.LivenessReport{Status: entities.CheckPassed, Details: c.probe.Details(r.Context())})
+}
+
+func (c *HealthController) ready(w http.ResponseWriter, r *http.Request) {
+	report := c.probe.Ready(r.Context())
+	response, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != entities.CheckPassed {
+		logging.FromContext(r.Context(), c.logger).Warningf("Readiness check failed: %s", response)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(response)
+}
@@ -3,6 +3,7 @@ package usecases
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 )
 
 func generateSessionToken() string {
@@ -13,3 +14,12 @@ func generateSessionToken() string {
 	}
 	return base64.StdEncoding.EncodeToString(b)
 }
+
+func generateWebhookSecret() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
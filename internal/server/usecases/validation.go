@@ -5,8 +5,14 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/infra/netguard"
 	"github.com/matthiasBT/gophermart/internal/server/entities"
 )
 
@@ -40,7 +46,7 @@ func validateUserAuthReq(w http.ResponseWriter, r *http.Request) *entities.UserA
 	return &userReq
 }
 
-func validateOrderNumber(w http.ResponseWriter, r *http.Request) *string {
+func validateOrderNumber(w http.ResponseWriter, r *http.Request, validator entities.IOrderValidator) *string {
 	if r.Header.Get("Content-Type") != "text/plain" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Supply data as plaintext"))
@@ -53,13 +59,15 @@ func validateOrderNumber(w http.ResponseWriter, r *http.Request) *string {
 		return nil
 	}
 	number := string(body)
-	if err := validatePlainOrderNumber(w, number); err != nil {
+	if err := validatePlainOrderNumber(w, number, validator); err != nil {
 		return nil
 	}
 	return &number
 }
 
-func validateWithdrawal(w http.ResponseWriter, r *http.Request, userID int) *entities.Accrual {
+func validateWithdrawal(
+	w http.ResponseWriter, r *http.Request, userID int, validator entities.IOrderValidator,
+) *entities.Accrual {
 	if r.Header.Get("Content-Type") != "application/json" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Supply data as JSON"))
@@ -77,7 +85,7 @@ func validateWithdrawal(w http.ResponseWriter, r *http.Request, userID int) *ent
 		w.Write([]byte("Failed to parse withdrawal request"))
 		return nil
 	}
-	if err := validatePlainOrderNumber(w, withdrawal.OrderNumber); err != nil {
+	if err := validatePlainOrderNumber(w, withdrawal.OrderNumber, validator); err != nil {
 		return nil
 	}
 	if withdrawal.Amount < 0 {
@@ -89,16 +97,301 @@ func validateWithdrawal(w http.ResponseWriter, r *http.Request, userID int) *ent
 	return &withdrawal
 }
 
-func validatePlainOrderNumber(w http.ResponseWriter, number string) error {
+func validatePlainOrderNumber(w http.ResponseWriter, number string, validator entities.IOrderValidator) error {
 	if len(number) < MinOrderNumberLength {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("The order number is too short"))
 		return errors.New("number is too short")
 	}
-	if err := goluhn.Validate(number); err != nil {
+	if err := validator.Validate(number); err != nil {
 		w.WriteHeader(http.StatusUnprocessableEntity)
-		w.Write([]byte("Invalid order number: Luhn algorithm check failed"))
-		return errors.New("non-Luhn order number")
+		w.Write([]byte("Invalid order number: " + err.Error()))
+		return err
 	}
 	return nil
 }
+
+func validateListFilter(w http.ResponseWriter, r *http.Request, withStatuses bool) *entities.ListFilter {
+	query := r.URL.Query()
+	filter := entities.ListFilter{Limit: config.DefaultPageSize}
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > config.MaxPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid limit"))
+			return nil
+		}
+		filter.Limit = value
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := entities.DecodeCursor(cursor)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid cursor"))
+			return nil
+		}
+		filter.After = after
+	}
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid sort direction: use asc or desc"))
+		return nil
+	}
+	var ok bool
+	if filter.From, ok = validateDateParam(w, r, "from"); !ok {
+		return nil
+	}
+	if filter.To, ok = validateDateParam(w, r, "to"); !ok {
+		return nil
+	}
+	if !withStatuses {
+		return &filter
+	}
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			parsed, err := entities.ParseOrderStatus(strings.ToUpper(strings.TrimSpace(status)))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Invalid order status: " + status))
+				return nil
+			}
+			filter.Statuses = append(filter.Statuses, parsed)
+		}
+	}
+	return &filter
+}
+
+func validateDateParam(w http.ResponseWriter, r *http.Request, name string) (*time.Time, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, true
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid " + name + " date: use RFC3339"))
+		return nil, false
+	}
+	return &parsed, true
+}
+
+func validateWebhookRequest(w http.ResponseWriter, r *http.Request, userID int) *entities.WebhookSubscription {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var webhookReq entities.WebhookSubscriptionRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &webhookReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse webhook request"))
+		return nil
+	}
+	target, err := url.Parse(webhookReq.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Webhook URL must be an absolute http(s) URL"))
+		return nil
+	}
+	if err := netguard.CheckHost(r.Context(), target.Hostname()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Webhook URL must point to a public address"))
+		return nil
+	}
+	if len(webhookReq.EventTypes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Subscribe to at least one event"))
+		return nil
+	}
+	for _, eventType := range webhookReq.EventTypes {
+		if !entities.KnownEventTypes[eventType] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unknown event: " + string(eventType)))
+			return nil
+		}
+	}
+	return &entities.WebhookSubscription{
+		UserID:     userID,
+		URL:        webhookReq.URL,
+		Secret:     webhookReq.Secret,
+		EventTypes: webhookReq.EventTypes,
+	}
+}
+
+func validatePoolResizeRequest(w http.ResponseWriter, r *http.Request) *entities.PoolResizeRequest {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var resizeReq entities.PoolResizeRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &resizeReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse pool resize request"))
+		return nil
+	}
+	return &resizeReq
+}
+
+func validateRateLimitRequest(w http.ResponseWriter, r *http.Request) *entities.RateLimitRequest {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var limitReq entities.RateLimitRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &limitReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse rate limit request"))
+		return nil
+	}
+	if limitReq.RequestsPerMinute < 0 || limitReq.Burst < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply a non-negative requests_per_minute and a positive burst"))
+		return nil
+	}
+	return &limitReq
+}
+
+func validateFailedOrderFilter(w http.ResponseWriter, r *http.Request) *entities.FailedOrderFilter {
+	listFilter := validateListFilter(w, r, true)
+	if listFilter == nil {
+		return nil
+	}
+	filter := entities.FailedOrderFilter{Statuses: listFilter.Statuses, Limit: listFilter.Limit}
+	if dead := r.URL.Query().Get("dead"); dead != "" {
+		value, err := strconv.ParseBool(dead)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid dead flag"))
+			return nil
+		}
+		filter.DeadOnly = value
+	}
+	return &filter
+}
+
+func validateRequeueRequest(w http.ResponseWriter, r *http.Request) *entities.FailedOrderFilter {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var filter entities.FailedOrderFilter
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &filter); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse requeue request"))
+		return nil
+	}
+	for _, status := range filter.Statuses {
+		if _, err := entities.ParseOrderStatus(string(status)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid order status: " + string(status)))
+			return nil
+		}
+	}
+	if filter.Limit == 0 {
+		filter.Limit = config.DefaultPageSize
+	}
+	if filter.Limit < 1 || filter.Limit > config.MaxPageSize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid limit"))
+		return nil
+	}
+	return &filter
+}
+
+func validateReconciliationRequest(w http.ResponseWriter, r *http.Request) *entities.ReconciliationRequest {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var reconcileReq entities.ReconciliationRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &reconcileReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse reconciliation request"))
+		return nil
+	}
+	if reconcileReq.From.IsZero() || reconcileReq.To.IsZero() || !reconcileReq.From.Before(reconcileReq.To) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply an RFC3339 date range with from before to"))
+		return nil
+	}
+	return &reconcileReq
+}
+
+func validateReconciliationID(w http.ResponseWriter, r *http.Request) *int64 {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid reconciliation id"))
+		return nil
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json", "csv":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid format: use json or csv"))
+		return nil
+	}
+	return &id
+}
+
+func validatePartnerAssignment(w http.ResponseWriter, r *http.Request) *entities.PartnerAssignment {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Supply data as JSON"))
+		return nil
+	}
+	var assignment entities.PartnerAssignment
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to read request body"))
+		return nil
+	}
+	if err := json.Unmarshal(body, &assignment); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to parse partner assignment"))
+		return nil
+	}
+	if assignment.Partner != nil && *assignment.Partner == "" {
+		assignment.Partner = nil
+	}
+	return &assignment
+}
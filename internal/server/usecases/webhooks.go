@@ -0,0 +1,151 @@
+package usecases
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matthiasBT/gophermart/internal/infra/config"
+	"github.com/matthiasBT/gophermart/internal/server/entities"
+)
+
+func (c *BaseController) createWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	subscription := validateWebhookRequest(w, r, *userID)
+	if subscription == nil {
+		return
+	}
+	if subscription.Secret == "" {
+		subscription.Secret = generateWebhookSecret()
+	}
+	result, err := c.webhookRepo.CreateSubscription(r.Context(), subscription)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to create a webhook subscription"))
+		return
+	}
+	response, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+func (c *BaseController) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	subscriptions, err := c.webhookRepo.FindUserSubscriptions(r.Context(), *userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find user's webhook subscriptions"))
+		return
+	}
+	if subscriptions == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	response, err := json.Marshal(subscriptions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (c *BaseController) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid webhook id"))
+		return
+	}
+	if err := c.webhookRepo.DeleteSubscription(r.Context(), *userID, id); err != nil {
+		if errors.Is(err, entities.ErrWebhookNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Webhook not found"))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete the webhook subscription"))
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *BaseController) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	status := entities.WebhookDeliveryStatus(strings.ToUpper(r.URL.Query().Get("status")))
+	switch status {
+	case "", entities.WebhookDeliveryPending, entities.WebhookDeliveryDelivered, entities.WebhookDeliveryDead:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid delivery status"))
+		return
+	}
+	deliveries, err := c.webhookRepo.FindUserDeliveries(r.Context(), *userID, status, config.MaxPageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to find user's webhook deliveries"))
+		return
+	}
+	if deliveries == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	response, err := json.Marshal(deliveries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to marshal the result"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (c *BaseController) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(w, r)
+	if userID == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid delivery id"))
+		return
+	}
+	if err := c.webhookRepo.Redeliver(r.Context(), *userID, id); err != nil {
+		if errors.Is(err, entities.ErrWebhookNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Webhook delivery not found"))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to schedule the redelivery"))
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}